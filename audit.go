@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// auditEntry is a single line of the audit file
+type auditEntry struct {
+	Time            string         `json:"time"`
+	Channel         string         `json:"channel,omitempty"`
+	Tag             string         `json:"tag"`
+	Reason          release.Reason `json:"reason"`
+	PublishedAt     string         `json:"published_at,omitempty"`
+	LastPublishedAt string         `json:"last_published_at,omitempty"`
+	ErrorCount      *int           `json:"error_count,omitempty"`
+}
+
+// auditLog appends json lines describing each release decision to a file.
+// A nil auditLog discards entries.
+type auditLog struct {
+	file *os.File
+	enc  *json.Encoder
+	// err is the first write error, reported by Err
+	err error
+}
+
+// openAuditLog opens path for appending, returning a nil auditLog if path is empty
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &auditLog{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record writes entry, stamping it with the current time.
+// Write errors are kept and reported by Err.
+func (a *auditLog) record(entry auditEntry) {
+	if a == nil || a.err != nil {
+		return
+	}
+	entry.Time = time.Now().UTC().Format(time.RFC3339)
+	err := a.enc.Encode(entry)
+	if err != nil {
+		a.err = fmt.Errorf("write audit file: %w", err)
+	}
+}
+
+// recordDecision writes an entry describing decision made while selecting channel
+func (a *auditLog) recordDecision(channel string, decision release.Decision) {
+	entry := auditEntry{
+		Channel:     channel,
+		Tag:         decision.Release.TagName,
+		Reason:      decision.Reason,
+		PublishedAt: decision.Release.PublishedAt,
+		ErrorCount:  decision.ErrorCount,
+	}
+	if !decision.LastPublishedAt.IsZero() {
+		entry.LastPublishedAt = decision.LastPublishedAt.Format(time.RFC3339)
+	}
+	a.record(entry)
+}
+
+// Err returns the first error encountered while recording
+func (a *auditLog) Err() error {
+	if a == nil {
+		return nil
+	}
+	return a.err
+}
+
+// Close closes the underlying file
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// releasesCache persists the releases listing so later runs can make conditional requests
+type releasesCache struct {
+	// path is the cache file, empty disables caching
+	path string
+	// fresh ignores any cached copy, the response is still saved
+	fresh bool
+	// readOnly never saves the response, for dry runs
+	readOnly bool
+	// stale allows using the cached listing when GitHub fails with a 5xx
+	stale bool
+}
+
+// releasesCacheJson is the on disk format of the releases cache
+type releasesCacheJson struct {
+	// URL is the first page url the cache was fetched from
+	URL      string             `json:"url"`
+	ETag     string             `json:"etag"`
+	Releases []*release.Release `json:"releases"`
+	// MaxBodyBytes is the body truncation the releases were decoded with, 0 if bodies are whole
+	MaxBodyBytes int `json:"max_body_bytes,omitempty"`
+}
+
+// load returns the cached listing for url, or nil if there is none
+func (c *releasesCache) load(url string) (*releasesCacheJson, error) {
+	if c == nil || c.path == "" || c.fresh {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read releases cache: %w", err)
+	}
+
+	cached := &releasesCacheJson{}
+	err = json.Unmarshal(data, cached)
+	if err != nil {
+		logger.Warn("ignoring releases cache that can't be decoded", "path", c.path, "err", err)
+		return nil, nil
+	}
+	// a listing with truncated bodies can't be reused by a run wanting more of them
+	if cached.URL != url || cached.ETag == "" || cached.MaxBodyBytes != maxBodyBytes {
+		return nil, nil
+	}
+	return cached, nil
+}
+
+// save writes the listing fetched from url to the cache
+func (c *releasesCache) save(url string, etag string, releases []*release.Release) error {
+	if c == nil || c.path == "" || c.readOnly || etag == "" {
+		return nil
+	}
+	data, err := json.Marshal(&releasesCacheJson{URL: url, ETag: etag, Releases: releases, MaxBodyBytes: maxBodyBytes})
+	if err != nil {
+		return fmt.Errorf("marshal releases cache: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(c.path), err)
+	}
+	err = writeFileAtomic(c.path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", c.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// channel is a set of selection rules whose release is written to its own file
+type channel struct {
+	name string
+	// optional channels are left unwritten when no release qualifies, rather than failing the run
+	optional bool
+	// rules returns the channel's selection rules given the configured stable rules
+	rules func(stable release.Options) release.Options
+}
+
+// channels are the channels that can be selected with -channels, in output order
+var channels = []channel{
+	{name: "stable", rules: func(stable release.Options) release.Options { return stable }},
+	{name: "unstable", rules: latestRules(release.PrereleasesSkip)},
+	{name: "bleeding", optional: true, rules: latestRules(release.PrereleasesOnly)},
+}
+
+// latestRules selects the highest version release without any age, keyword or crash gates,
+// unless -channel-rule adds them
+func latestRules(prereleases release.PrereleaseMode) func(release.Options) release.Options {
+	return func(stable release.Options) release.Options {
+		return release.Options{
+			AllowNonSemver: stable.AllowNonSemver,
+			TagPrefix:      stable.TagPrefix,
+			Since:          stable.Since,
+			Prereleases:    prereleases,
+			OnDecision:     stable.OnDecision,
+			Logger:         stable.Logger,
+			Now:            stable.Now,
+		}
+	}
+}
+
+// parseChannels parses a comma separated list of channel names, returning them in output order
+func parseChannels(value string) ([]channel, error) {
+	wanted := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, c := range channels {
+			known = known || c.name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown channel %q, expected stable, unstable or bleeding", name)
+		}
+		wanted[name] = true
+	}
+	if len(wanted) == 0 {
+		return nil, fmt.Errorf("no channels given")
+	}
+	selected := []channel{}
+	for _, c := range channels {
+		if wanted[c.name] {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+// channelRules overrides some of one channel's gates, nil fields keep the channel's own
+type channelRules struct {
+	minAge          *time.Duration
+	minGap          *time.Duration
+	fallbackAge     *time.Duration
+	keywords        []string
+	minFixes        *int
+	maxCrashServers *int
+	crashCheck      *bool
+}
+
+// channelRulesFlag is the repeatable -channel-rule flag, each value channel.key=value overriding
+// one gate of a channel, e.g. unstable.min-age=24h. A config file can give it as a table
+// of channels to keys instead.
+type channelRulesFlag map[string]*channelRules
+
+func (f channelRulesFlag) String() string {
+	names := []string{}
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f channelRulesFlag) Set(value string) error {
+	key, text, ok := strings.Cut(value, "=")
+	name, key, dotted := strings.Cut(key, ".")
+	if !ok || !dotted {
+		return fmt.Errorf("expected channel.key=value, got %q", value)
+	}
+	known := false
+	for _, c := range channels {
+		known = known || c.name == name
+	}
+	if !known {
+		return fmt.Errorf("unknown channel %q, expected stable, unstable or bleeding", name)
+	}
+	rules := f[name]
+	if rules == nil {
+		rules = &channelRules{}
+		f[name] = rules
+	}
+
+	switch key {
+	case "min-age", "min-gap", "fallback-age":
+		d, err := parseDuration(name+"."+key, text)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "min-age":
+			rules.minAge = &d
+		case "min-gap":
+			rules.minGap = &d
+		default:
+			rules.fallbackAge = &d
+		}
+	case "require-keyword":
+		rules.keywords = append(rules.keywords, text)
+	case "min-fixes", "max-crash-servers":
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s.%s must be a non-negative number, got %q", name, key, text)
+		}
+		if key == "min-fixes" {
+			rules.minFixes = &n
+		} else {
+			rules.maxCrashServers = &n
+		}
+	case "crash-check":
+		check, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s.%s must be true or false, got %q", name, key, text)
+		}
+		rules.crashCheck = &check
+	default:
+		return fmt.Errorf("unknown channel rule %q, expected min-age, min-gap, fallback-age, require-keyword, min-fixes, max-crash-servers or crash-check", key)
+	}
+	return nil
+}
+
+// apply returns rules, the selection rules of the channel called name, with its overrides.
+// A channel without gates of its own takes the fallback age of stable, so the fallback
+// doesn't pick the newest release whatever the gates say, and turning the crash gate on,
+// or setting max-crash-servers, borrows the crash check of stable.
+func (f channelRulesFlag) apply(name string, rules release.Options, stable release.Options) release.Options {
+	override := f[name]
+	if override == nil {
+		return rules
+	}
+	if rules.FallbackAge == 0 {
+		rules.FallbackAge = stable.FallbackAge
+	}
+	if override.fallbackAge != nil {
+		rules.FallbackAge = *override.fallbackAge
+	}
+	if override.minAge != nil {
+		rules.MinAge = *override.minAge
+	}
+	if override.minGap != nil {
+		rules.MinGap = *override.minGap
+	}
+	if len(override.keywords) > 0 {
+		rules.Keywords = override.keywords
+		// a keyword is required on at least one line unless min-fixes says otherwise
+		rules.MinFixes = max(rules.MinFixes, 1)
+	}
+	if override.minFixes != nil {
+		rules.MinFixes = *override.minFixes
+	}
+	if override.maxCrashServers != nil {
+		rules.MaxCrashServers = *override.maxCrashServers
+	}
+	crashCheck := override.maxCrashServers != nil
+	if override.crashCheck != nil {
+		crashCheck = *override.crashCheck
+	}
+	if crashCheck && rules.CrashCount == nil {
+		rules.CrashCount = stable.CrashCount
+	}
+	if override.crashCheck != nil && !*override.crashCheck {
+		rules.CrashCount = nil
+	}
+
+	// policies built from the flags are rebuilt from the overrides, adding the age and keyword
+	// gates when they're overridden but weren't in -policies
+	if rules.Policies == nil {
+		return rules
+	}
+	keywordsSet := len(override.keywords) > 0 || override.minFixes != nil
+	hasAge, hasKeywords := false, false
+	policies := []release.SelectionPolicy{}
+	for _, policy := range rules.Policies {
+		switch policy.(type) {
+		case release.MinAgePolicy:
+			policy = release.MinAgePolicy{MinAge: rules.MinAge}
+			hasAge = true
+		case release.KeywordPolicy:
+			policy = release.KeywordPolicy{Keywords: rules.Keywords, MinFixes: rules.MinFixes}
+			hasKeywords = true
+		}
+		policies = append(policies, policy)
+	}
+	if override.minAge != nil && !hasAge {
+		policies = append(policies, release.MinAgePolicy{MinAge: rules.MinAge})
+	}
+	if keywordsSet && !hasKeywords {
+		policies = append(policies, release.KeywordPolicy{Keywords: rules.Keywords, MinFixes: rules.MinFixes})
+	}
+	rules.Policies = policies
+	return rules
+}
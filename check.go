@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// runCheck fetches the release of repo tagged tag and writes whether each stable gate
+// passed to w, returning an error wrapping release.ErrNoRelease if any failed
+func runCheck(ctx context.Context, repo string, tag string, opts release.Options, w io.Writer) error {
+	rel, err := githubRelease(ctx, repo, tag)
+	if err != nil {
+		return &release.StageError{Stage: release.ErrGitHubFetch, Err: fmt.Errorf("githubRelease: %w", err)}
+	}
+	gates, err := release.CheckRelease(rel, opts)
+	if err != nil {
+		return err
+	}
+
+	passed := true
+	for _, gate := range gates {
+		result := "pass"
+		if !gate.Passed {
+			result = "FAIL"
+			passed = false
+		}
+		fmt.Fprintf(w, "%-10s %s  %s\n", gate.Name, result, gate.Detail)
+	}
+	if !passed {
+		return fmt.Errorf("%s wouldn't qualify as stable: %w", tag, release.ErrNoRelease)
+	}
+	fmt.Fprintf(w, "%s would qualify as stable\n", tag)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// isChecksumAsset reports if asset publishes checksums of the other assets
+func isChecksumAsset(asset release.Asset) bool {
+	return asset.Name == "checksums.txt" || strings.HasSuffix(asset.Name, ".sha256")
+}
+
+// verifyChecksums compares the sha256 of each downloaded asset in dir against the checksums
+// published with rel. Assets without a published checksum are an error when require is set,
+// as is a release without any checksum files. When verification fails the downloaded assets
+// are removed, so a tampered or unverified file isn't left for the deploy to pick up.
+func verifyChecksums(ctx context.Context, rel *release.Release, dir string, downloaded []release.Asset, require bool) error {
+	err := checkChecksums(ctx, rel, dir, downloaded, require)
+	if err == nil {
+		return nil
+	}
+	for _, asset := range downloaded {
+		path := filepath.Join(dir, filepath.Base(asset.Name))
+		removeErr := os.Remove(path)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			logger.Warn("failed to remove unverified asset", "path", path, "err", removeErr)
+		}
+	}
+	return err
+}
+
+// checkChecksums does the verification for verifyChecksums, leaving the files in place
+func checkChecksums(ctx context.Context, rel *release.Release, dir string, downloaded []release.Asset, require bool) error {
+	sums := map[string]string{}
+	found := false
+	for _, asset := range rel.Assets {
+		if !isChecksumAsset(asset) {
+			continue
+		}
+		found = true
+		data, err := fetchChecksums(ctx, asset.BrowserDownloadURL)
+		if err != nil {
+			return fmt.Errorf("fetch %s: %w", asset.Name, err)
+		}
+		for name, sum := range parseChecksums(data, strings.TrimSuffix(asset.Name, ".sha256")) {
+			sums[name] = sum
+		}
+	}
+	if !found {
+		if require {
+			return fmt.Errorf("%s has no checksums file", rel.TagName)
+		}
+		logger.Warn("no checksums published, skipping verification", "tag", rel.TagName)
+		return nil
+	}
+
+	for _, asset := range downloaded {
+		if isChecksumAsset(asset) {
+			continue
+		}
+		want, ok := sums[asset.Name]
+		if !ok {
+			if require {
+				return fmt.Errorf("no checksum published for %s", asset.Name)
+			}
+			logger.Warn("no checksum published for asset, skipping verification", "asset", asset.Name)
+			continue
+		}
+		path := filepath.Join(dir, filepath.Base(asset.Name))
+		got, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("checksum mismatch for %s: got %s want %s", asset.Name, got, want)
+		}
+		logger.Debug("verified checksum", "asset", asset.Name)
+	}
+	return nil
+}
+
+// parseChecksums parses lines in the "<hex>  <filename>" format written by sha256sum.
+// A line with only a hex digest is taken to be the checksum of defaultName.
+func parseChecksums(data string, defaultName string) map[string]string {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch len(fields) {
+		case 1:
+			sums[defaultName] = strings.ToLower(fields[0])
+		case 2:
+			// sha256sum prefixes names with * in binary mode
+			sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+		}
+	}
+	return sums
+}
+
+// fetchChecksums downloads a checksums file
+func fetchChecksums(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("new request: %w", err)
+	}
+	resp, err := doWithRetry(client, req)
+	if err != nil {
+		return "", fmt.Errorf("get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", url, err)
+	}
+	return string(data), nil
+}
+
+// fileSHA256 returns the hex sha256 digest of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+func TestParseChecksums(t *testing.T) {
+	data := "ABC123  eqemu-server-linux-x64.zip\ndef456 *eqemu-server-windows-x64.zip\n\n"
+	want := map[string]string{
+		"eqemu-server-linux-x64.zip":   "abc123",
+		"eqemu-server-windows-x64.zip": "def456",
+	}
+	got := parseChecksums(data, "checksums.txt")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChecksums() = %v, want %v", got, want)
+	}
+
+	got = parseChecksums("abc123\n", "eqemu-server-linux-x64.zip")
+	want = map[string]string{"eqemu-server-linux-x64.zip": "abc123"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChecksums() single digest = %v, want %v", got, want)
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	data := []byte("server binary")
+	sum := sha256.Sum256(data)
+	good := hex.EncodeToString(sum[:])
+	checksums := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(checksums))
+	}))
+	defer server.Close()
+	oldClient := client
+	client = server.Client()
+	defer func() { client = oldClient }()
+
+	asset := release.Asset{Name: "eqemu-server-linux-x64.zip"}
+	rel := &release.Release{
+		TagName: "v2.0.0",
+		Assets:  []release.Asset{asset, {Name: "checksums.txt", BrowserDownloadURL: server.URL + "/checksums.txt"}},
+	}
+	tests := []struct {
+		name      string
+		checksums string
+		require   bool
+		wantErr   string
+	}{
+		{name: "match", checksums: good + "  eqemu-server-linux-x64.zip\n"},
+		{name: "mismatch", checksums: strings.Repeat("0", 64) + "  eqemu-server-linux-x64.zip\n", wantErr: "checksum mismatch"},
+		{name: "missing", checksums: good + "  other.zip\n"},
+		{name: "missing and required", checksums: good + "  other.zip\n", require: true, wantErr: "no checksum published"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, asset.Name)
+			err := os.WriteFile(path, data, 0644)
+			if err != nil {
+				t.Fatalf("write asset: %v", err)
+			}
+			checksums = tt.checksums
+
+			err = verifyChecksums(context.Background(), rel, dir, []release.Asset{asset}, tt.require)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("verifyChecksums() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("verifyChecksums() error = %v, want %q", err, tt.wantErr)
+			}
+			// an asset failing verification mustn't be left for the deploy
+			_, err = os.Stat(path)
+			if exists := err == nil; exists != (tt.wantErr == "") {
+				t.Errorf("asset exists = %t after verification, want %t", exists, tt.wantErr == "")
+			}
+		})
+	}
+}
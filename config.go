@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig reads a YAML or TOML file, chosen by its extension, whose keys are flag
+// names, and sets each flag that wasn't given on the command line. Unknown keys fail.
+// Flags set from the file are visited by fs.Visit like those given on the command line.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	values := map[string]any{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unknown config file type %q, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	// flags given on the command line override the file
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("config %s: unknown key %q", path, key)
+		}
+		if given[key] {
+			continue
+		}
+		err = setConfigValue(fs, f, values[key])
+		if err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// setConfigValue sets f in fs from a decoded config value. Lists are only allowed for
+// repeatable flags, and tables for channel-rule.
+func setConfigValue(fs *flag.FlagSet, f *flag.Flag, value any) error {
+	switch value := value.(type) {
+	case nil:
+		return fmt.Errorf("missing value")
+	case map[string]any:
+		if _, ok := f.Value.(channelRulesFlag); !ok {
+			return fmt.Errorf("expected a value, got a table")
+		}
+		return setChannelRules(fs, f, value)
+	case []any:
+		_, repeatable := f.Value.(*stringList)
+		if !repeatable {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		for _, item := range value {
+			err := fs.Set(f.Name, configString(item))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fs.Set(f.Name, configString(value))
+}
+
+// configString formats a decoded config value as it would be given on the command line.
+// Unquoted dates decode as times, which are written as YYYY-MM-DD, or RFC 3339 if they have a time of day.
+func configString(value any) string {
+	t, ok := value.(time.Time)
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	if t.Equal(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())) {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// setChannelRules sets channel-rule from a table of channels to tables of keys, e.g.
+// unstable: {min-age: 24h}, as if each was given as unstable.min-age=24h
+func setChannelRules(fs *flag.FlagSet, f *flag.Flag, table map[string]any) error {
+	names := []string{}
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys, ok := table[name].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected a table of rules", name)
+		}
+		sorted := []string{}
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			values, ok := keys[key].([]any)
+			if !ok {
+				values = []any{keys[key]}
+			}
+			for _, value := range values {
+				err := fs.Set(f.Name, fmt.Sprintf("%s.%s=%s", name, key, configString(value)))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
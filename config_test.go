@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		file         string
+		data         string
+		args         []string
+		wantRepo     string
+		wantMinAge   string
+		wantKeywords []string
+		wantSince    string
+		wantErr      string
+	}{
+		{
+			name:         "yaml",
+			file:         "config.yaml",
+			data:         "repo: example/server\nmin-age: 48h\nrequire-keyword: [fix, crash]\n",
+			wantRepo:     "example/server",
+			wantMinAge:   "48h",
+			wantKeywords: []string{"fix", "crash"},
+		},
+		{
+			name:       "toml",
+			file:       "config.toml",
+			data:       "repo = \"example/server\"\nmin-age = \"48h\"\n",
+			wantRepo:   "example/server",
+			wantMinAge: "48h",
+		},
+		{
+			name:       "yaml date",
+			file:       "config.yaml",
+			data:       "since: 2024-01-02\n",
+			wantRepo:   "eqemu/server",
+			wantMinAge: "168h",
+			wantSince:  "2024-01-02",
+		},
+		{
+			name:       "toml date",
+			file:       "config.toml",
+			data:       "since = 2024-01-02\n",
+			wantRepo:   "eqemu/server",
+			wantMinAge: "168h",
+			wantSince:  "2024-01-02",
+		},
+		{
+			name:       "flags override file",
+			file:       "config.yaml",
+			data:       "repo: example/server\nmin-age: 48h\n",
+			args:       []string{"-min-age", "24h"},
+			wantRepo:   "example/server",
+			wantMinAge: "24h",
+		},
+		{
+			name:    "unknown key",
+			file:    "config.yaml",
+			data:    "min_age: 48h\n",
+			wantErr: `unknown key "min_age"`,
+		},
+		{
+			name:    "list for a single value",
+			file:    "config.yaml",
+			data:    "repo: [a/b, c/d]\n",
+			wantErr: "expected a single value",
+		},
+		{
+			name:    "unknown extension",
+			file:    "config.json",
+			data:    "{}",
+			wantErr: "unknown config file type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.data), 0644)
+			if err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			opts := &options{}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.StringVar(&opts.repo, "repo", "eqemu/server", "")
+			fs.StringVar(&opts.minAge, "min-age", "168h", "")
+			fs.Var(&opts.keywords, "require-keyword", "")
+			fs.StringVar(&opts.since, "since", "", "")
+			err = fs.Parse(tt.args)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			err = loadConfig(fs, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if opts.repo != tt.wantRepo {
+				t.Errorf("repo = %q, want %q", opts.repo, tt.wantRepo)
+			}
+			if opts.since != tt.wantSince {
+				t.Errorf("since = %q, want %q", opts.since, tt.wantSince)
+			}
+			if opts.minAge != tt.wantMinAge {
+				t.Errorf("min-age = %q, want %q", opts.minAge, tt.wantMinAge)
+			}
+			if !reflect.DeepEqual([]string(opts.keywords), tt.wantKeywords) {
+				t.Errorf("keywords = %q, want %q", opts.keywords, tt.wantKeywords)
+			}
+		})
+	}
+}
+
+// TestLoadConfigVisited checks values from the file count as set, so a min-age in the file
+// conflicts with -trail-count like one on the command line
+func TestLoadConfigVisited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte("min-age: 48h\n"), 0644)
+	if err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	opts := &options{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&opts.minAge, "min-age", "168h", "")
+	fs.IntVar(&opts.trailCount, "trail-count", 0, "")
+	err = fs.Parse([]string{"-trail-count", "2"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	err = loadConfig(fs, path)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	visited := []string{}
+	fs.Visit(func(f *flag.Flag) {
+		visited = append(visited, f.Name)
+	})
+	if !reflect.DeepEqual(visited, []string{"min-age", "trail-count"}) {
+		t.Errorf("visited %v, want min-age and trail-count", visited)
+	}
+}
+
+func TestLoadConfigChannelRules(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: "channel-rule:\n  unstable:\n    min-age: 24h\n    require-keyword: [fix, crash]\n    crash-check: true\n",
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			data: "[channel-rule.unstable]\nmin-age = \"24h\"\nrequire-keyword = [\"fix\", \"crash\"]\ncrash-check = true\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := os.WriteFile(path, []byte(tt.data), 0644)
+			if err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			rules := channelRulesFlag{}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Var(rules, "channel-rule", "")
+
+			err = loadConfig(fs, path)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			unstable := rules["unstable"]
+			if unstable == nil || unstable.minAge == nil || *unstable.minAge != 24*time.Hour {
+				t.Fatalf("unstable rules = %+v, want min-age 24h", unstable)
+			}
+			if !reflect.DeepEqual(unstable.keywords, []string{"fix", "crash"}) {
+				t.Errorf("keywords = %q, want fix and crash", unstable.keywords)
+			}
+			if unstable.crashCheck == nil || !*unstable.crashCheck {
+				t.Errorf("crash-check = %v, want true", unstable.crashCheck)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// crashReportURL is the Spire analytics endpoint crash reports are fetched from
+var crashReportURL = "https://spire.akkadius.com/api/v1/analytics/server-crash-reports"
+
+// crashDedupeKey is the crash report field servers are told apart by, name or shortname
+var crashDedupeKey = "name"
+
+// crashNoCache asks caches in front of Spire to revalidate crash report requests
+var crashNoCache bool
+
+// crashWindow is how recent a crash report must be to be counted, 0 counts every report
+var crashWindow time.Duration
+
+// crashNow returns the time the crash window ends at, the selection clock
+var crashNow = time.Now
+
+// adoptionURL is the endpoint listing the servers running a version, for -min-adoption
+var adoptionURL string
+
+// crashCounts memoizes errorCount, or adoptionCount, by version for a single run, so a version
+// checked by several channels or repos is only queried once. It isn't persisted, to keep counts fresh.
+type crashCounts struct {
+	mu      sync.Mutex
+	results map[string]*crashCountResult
+	count   func(ctx context.Context, tag string) (int, error)
+}
+
+// crashCountResult is the outcome of querying one version, computed once
+type crashCountResult struct {
+	once  sync.Once
+	count int
+	err   error
+}
+
+func newCrashCounts() *crashCounts {
+	return &crashCounts{results: map[string]*crashCountResult{}, count: errorCount}
+}
+
+func newAdoptionCounts() *crashCounts {
+	return &crashCounts{results: map[string]*crashCountResult{}, count: adoptionCount}
+}
+
+// get returns the count for tag, querying it only the first time tag is asked for.
+// Concurrent callers asking for the same tag wait for the one query.
+func (c *crashCounts) get(ctx context.Context, tag string) (int, error) {
+	c.mu.Lock()
+	result, ok := c.results[tag]
+	if !ok {
+		result = &crashCountResult{}
+		c.results[tag] = result
+	}
+	c.mu.Unlock()
+	result.once.Do(func() {
+		result.count, result.err = c.count(ctx, tag)
+	})
+	if ok {
+		logger.Debug("reusing crash count", "version", tag, "count", result.count)
+	}
+	return result.count, result.err
+}
+
+// crashQueryURL returns crashReportURL asking for the reports of version
+func crashQueryURL(version string) (string, error) {
+	return versionQueryURL(crashReportURL, version)
+}
+
+// versionQueryURL returns base asking for version, keeping any query it already has and
+// escaping version, whose +build suffix would otherwise decode as a space
+func versionQueryURL(base string, version string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", base, err)
+	}
+	query := u.Query()
+	query.Set("version", version)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// serverReportJson is a server entry of a Spire crash report, or of an adoption endpoint
+// sharing its schema
+type serverReportJson struct {
+	Id              int    `json:"id"`
+	ServerName      string `json:"server_name"`
+	ServerShortName string `json:"server_short_name"`
+	ServerVersion   string `json:"server_version"`
+	CreatedAt       string `json:"created_at"`
+}
+
+// serverKey returns what report's server is told apart by, following crashDedupeKey
+func serverKey(report *serverReportJson) string {
+	// instances of one server can report different display names but share a short name
+	if crashDedupeKey == "shortname" {
+		return report.ServerShortName
+	}
+	return report.ServerName
+}
+
+// fetchServerReports fetches the json array of server entries reportURL returns, with what
+// naming the request in errors
+func fetchServerReports(ctx context.Context, reportURL string, what string) ([]*serverReportJson, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reportURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	// a stale cached zero could promote a crashing release
+	if crashNoCache {
+		req.Header.Set("Cache-Control", "no-cache")
+		req.Header.Set("Pragma", "no-cache")
+	}
+
+	resp, err := doWithRetry(crashClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s %s: %w", what, reportURL, err)
+	}
+	defer resp.Body.Close()
+
+	// read resp body to buf
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s %s: %w", what, reportURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s %s: unexpected status %s: %s", what, reportURL, resp.Status, bodySnippet(data))
+	}
+	// an outage can serve an html error page with a 200
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return nil, fmt.Errorf("get %s %s: expected json, got %s: %s", what, reportURL, contentType, bodySnippet(data))
+		}
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '[' {
+		return nil, fmt.Errorf("get %s %s: expected an array: %s", what, reportURL, bodySnippet(data))
+	}
+	payloads := []*serverReportJson{}
+	err = json.Unmarshal(data, &payloads)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s %s: %s: %w: %s", what, reportURL, resp.Status, err, bodySnippet(data))
+	}
+	return payloads, nil
+}
+
+func errorCount(ctx context.Context, tag string) (int, error) {
+	reportURL, err := crashQueryURL(tag)
+	if err != nil {
+		return 0, err
+	}
+	payloads, err := fetchServerReports(ctx, reportURL, "error count")
+	if err != nil {
+		return 0, err
+	}
+
+	servers := make(map[string]string)
+	count := 0
+	filtered := 0
+	outsideWindow := 0
+	windowStart := crashNow().Add(-crashWindow)
+	for _, payload := range payloads {
+		// the endpoint may match versions by prefix, so 1.2 reports can come back for 1.2.0
+		if payload.ServerVersion != tag {
+			filtered++
+			continue
+		}
+		// a report without a usable timestamp might be recent, so it's counted
+		if crashWindow > 0 {
+			createdAt, err := release.ParseTimestamp(payload.CreatedAt)
+			if err == nil && createdAt.Before(windowStart) {
+				outsideWindow++
+				continue
+			}
+		}
+		key := serverKey(payload)
+		if _, ok := servers[key]; ok {
+			continue
+		}
+		servers[key] = payload.ServerName
+		count++
+	}
+	if filtered > 0 {
+		logger.Info("ignored crash reports for other versions", "version", tag, "ignored", filtered, "kept", len(payloads)-filtered)
+	}
+	if outsideWindow > 0 {
+		logger.Info("ignored crash reports outside the crash window", "version", tag, "ignored", outsideWindow, "crash_window", crashWindow)
+	}
+
+	return count, nil
+}
+
+// adoptionCount returns how many distinct servers adoptionURL lists as running tag. It's asked
+// for tag with a version query parameter like the crash report endpoint and returns the same
+// schema, one entry per server, deduplicated by crashDedupeKey.
+func adoptionCount(ctx context.Context, tag string) (int, error) {
+	reportURL, err := versionQueryURL(adoptionURL, tag)
+	if err != nil {
+		return 0, err
+	}
+	payloads, err := fetchServerReports(ctx, reportURL, "adoption")
+	if err != nil {
+		return 0, err
+	}
+	servers := map[string]bool{}
+	for _, payload := range payloads {
+		// like crash reports, the endpoint may match versions by prefix
+		if payload.ServerVersion == tag {
+			servers[serverKey(payload)] = true
+		}
+	}
+	return len(servers), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errChanged is returned by the diff subcommand when an output file would change
+var errChanged = errors.New("output files would change")
+
+// diffOutputs writes a unified diff between each output file on disk and what would be
+// written in its place, returning errChanged if any differ
+func diffOutputs(w io.Writer, outputs []outputFile) error {
+	changed := 0
+	for _, output := range outputs {
+		existing, err := os.ReadFile(output.path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("read %s: %w", output.path, err)
+		}
+		if bytes.Equal(existing, output.data) {
+			continue
+		}
+		changed++
+		writeDiff(w, output.path, string(existing), string(output.data))
+	}
+	if changed > 0 {
+		return errChanged
+	}
+	return nil
+}
+
+// writeDiff writes a unified diff replacing every line of old with every line of new.
+// Output files are a line or a small json document, so a single hunk is enough.
+func writeDiff(w io.Writer, path string, old string, new string) {
+	oldLines := diffLines(old)
+	newLines := diffLines(new)
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", path, path)
+	fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(len(oldLines)), hunkRange(len(newLines)))
+	for _, line := range oldLines {
+		fmt.Fprintf(w, "-%s\n", line)
+	}
+	for _, line := range newLines {
+		fmt.Fprintf(w, "+%s\n", line)
+	}
+}
+
+// diffLines splits data into lines, with no lines for empty data
+func diffLines(data string) []string {
+	data = strings.TrimSuffix(data, "\n")
+	if data == "" {
+		return nil
+	}
+	return strings.Split(data, "\n")
+}
+
+// hunkRange formats a unified diff range covering the first n lines
+func hunkRange(n int) string {
+	if n == 0 {
+		return "0,0"
+	}
+	if n == 1 {
+		return "1"
+	}
+	return fmt.Sprintf("1,%d", n)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// matchingAssets returns the assets of rel whose name matches the glob pattern, all of them if pattern is empty
+func matchingAssets(rel *release.Release, pattern string) ([]release.Asset, error) {
+	assets := []release.Asset{}
+	for _, asset := range rel.Assets {
+		if pattern != "" {
+			ok, err := path.Match(pattern, asset.Name)
+			if err != nil {
+				return nil, fmt.Errorf("asset pattern %q: %w", pattern, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// downloadAssets streams each asset into dir, named after the asset
+func downloadAssets(ctx context.Context, dir string, assets []release.Asset) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	for _, asset := range assets {
+		dst := filepath.Join(dir, filepath.Base(asset.Name))
+		logger.Debug("downloading asset", "asset", asset.Name, "path", dst)
+		err = downloadFile(ctx, asset.BrowserDownloadURL, dst)
+		if err != nil {
+			return fmt.Errorf("download %s: %w", asset.Name, err)
+		}
+	}
+	return nil
+}
+
+// sourceArchive is a source archive GitHub generates for a release
+type sourceArchive struct {
+	url string
+	// name is the file name in the out dir, after the tag
+	name string
+}
+
+// newSourceArchive returns the tar or zip source archive of rel
+func newSourceArchive(rel *release.Release, kind string) (sourceArchive, error) {
+	// tags may contain slashes, which aren't allowed in a file name
+	name := strings.ReplaceAll(rel.TagName, "/", "-")
+	archive := sourceArchive{url: rel.TarballURL, name: name + ".tar.gz"}
+	if kind == "zip" {
+		archive = sourceArchive{url: rel.ZipballURL, name: name + ".zip"}
+	}
+	if archive.url == "" {
+		return sourceArchive{}, fmt.Errorf("release %s has no %s source archive", rel.TagName, kind)
+	}
+	return archive, nil
+}
+
+// downloadSource streams the source archive into dir, following the redirect GitHub issues
+// to its archive host
+func downloadSource(ctx context.Context, dir string, archive sourceArchive) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	dst := filepath.Join(dir, archive.name)
+	logger.Debug("downloading source archive", "url", archive.url, "path", dst)
+	err = downloadFile(ctx, archive.url, dst)
+	if err != nil {
+		return fmt.Errorf("download source archive: %w", err)
+	}
+	return nil
+}
+
+// downloadFile streams url to dst, writing to a temporary file first so a failed download
+// never leaves a partial file at dst
+func downloadFile(ctx context.Context, url string, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	// downloads can take longer than the api timeout, so only the context bounds them
+	downloadClient := &http.Client{Transport: client.Transport}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmp, err)
+	}
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("close %s: %w", tmp, err)
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return nil
+}
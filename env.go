@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// envNames are the variables each channel's tag is printed as with -format env, in output order
+var envNames = []struct {
+	channel string
+	name    string
+}{
+	{channel: "stable", name: "EQEMU_STABLE"},
+	{channel: "unstable", name: "EQEMU_LATEST"},
+	{channel: "bleeding", name: "EQEMU_BLEEDING"},
+	{channel: "prerelease", name: "EQEMU_PRERELEASE"},
+}
+
+// shellSafe matches values that can be assigned in a shell without quoting
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9._+:/@-]+$`)
+
+// envOutput renders the selected tags as shell assignments, one per line, for
+// source <(server -format env). Channels that weren't selected are left out.
+func envOutput(selected map[string]*release.Release) []byte {
+	buf := &bytes.Buffer{}
+	for _, env := range envNames {
+		rel, ok := selected[env.channel]
+		if !ok || rel == nil {
+			continue
+		}
+		fmt.Fprintf(buf, "%s=%s\n", env.name, shellQuote(rel.TagName))
+	}
+	return buf.Bytes()
+}
+
+// shellQuote single quotes value unless it only holds characters a shell leaves alone
+func shellQuote(value string) string {
+	if shellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
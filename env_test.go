@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+func TestEnvOutput(t *testing.T) {
+	selected := map[string]*release.Release{
+		"unstable": {TagName: "v2.0.0"},
+		"stable":   {TagName: "v1.9.0"},
+		"bleeding": {TagName: "v2.1.0 it's $(odd)"},
+	}
+	want := "EQEMU_STABLE=v1.9.0\nEQEMU_LATEST=v2.0.0\nEQEMU_BLEEDING='v2.1.0 it'\\''s $(odd)'\n"
+	got := string(envOutput(selected))
+	if got != want {
+		t.Errorf("envOutput() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// githubAPIBase is the GitHub API url releases are fetched from
+var githubAPIBase = "https://api.github.com"
+
+// githubToken is sent as a bearer token with GitHub API requests if set
+var githubToken string
+
+// maxBodyBytes truncates each decoded release body to this many bytes, 0 keeps bodies whole
+var maxBodyBytes int
+
+// readGithubToken returns the token in tokenFile with surrounding whitespace trimmed, or
+// GITHUB_TOKEN if tokenFile isn't set. The token itself is never included in an error.
+func readGithubToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		return os.Getenv("GITHUB_TOKEN"), nil
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+// rateLimitError is returned when GitHub refuses a request due to the rate limit
+type rateLimitError struct {
+	// Reset is when the rate limit window resets, zero if unknown
+	Reset time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	if e.Reset.IsZero() {
+		return "github rate limit exceeded"
+	}
+	return fmt.Sprintf("github rate limit exceeded, resets at %s", e.Reset.Local().Format(time.RFC1123))
+}
+
+// githubServerError is a 5xx response from GitHub, e.g. during an incident
+type githubServerError struct {
+	err error
+}
+
+func (e *githubServerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *githubServerError) Unwrap() error {
+	return e.err
+}
+
+// maxReleasePages caps how many pages of releases are fetched
+const maxReleasePages = 50
+
+// githubReleases fetches every release of repo, given as owner/name.
+// When cache holds a listing whose first page is unchanged it's returned without fetching further pages,
+// and if cache allows stale listings it's also returned when GitHub fails with a 5xx.
+func githubReleases(ctx context.Context, repo string, cache *releasesCache) ([]*release.Release, error) {
+	base, err := url.Parse(githubAPIBase)
+	if err != nil {
+		return nil, fmt.Errorf("parse github api base: %w", err)
+	}
+
+	firstURL := fmt.Sprintf("%s/repos/%s/releases?per_page=100", githubAPIBase, repo)
+	cached, err := cache.load(firstURL)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []*release.Release{}
+	etag := ""
+	pageURL := firstURL
+	for page := 0; pageURL != ""; page++ {
+		if page >= maxReleasePages {
+			logger.Warn("stopping release fetch at page limit", "pages", maxReleasePages)
+			break
+		}
+		ifNoneMatch := ""
+		if page == 0 && cached != nil {
+			ifNoneMatch = cached.ETag
+		}
+		result, err := githubReleasesPage(ctx, pageURL, ifNoneMatch)
+		var serverErr *githubServerError
+		if errors.As(err, &serverErr) && cached != nil && cache.stale {
+			logger.Warn("github unavailable, using cached releases", "path", cache.path, "err", err)
+			return cached.Releases, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page+1, err)
+		}
+		// a 304 only answers a conditional request, without one there's no cached listing it refers to
+		if result.notModified && ifNoneMatch == "" {
+			return nil, fmt.Errorf("page %d: get releases %s: not modified without an If-None-Match etag", page+1, pageURL)
+		}
+		if result.notModified {
+			logger.Debug("releases unchanged since last run, using cache", "path", cache.path)
+			return cached.Releases, nil
+		}
+		if page == 0 {
+			etag = result.etag
+		}
+		releases = append(releases, result.releases...)
+
+		// only follow pages on the configured host so the token isn't sent elsewhere
+		if result.next != "" {
+			nextURL, err := url.Parse(result.next)
+			if err != nil {
+				return nil, fmt.Errorf("parse next page url: %w", err)
+			}
+			if nextURL.Host != base.Host {
+				return nil, fmt.Errorf("next page %s is not on %s", result.next, base.Host)
+			}
+		}
+		pageURL = result.next
+	}
+
+	err = cache.save(firstURL, etag, releases)
+	if err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// releasesPage is a single page of the releases listing
+type releasesPage struct {
+	releases []*release.Release
+	// next is the url of the next page, empty on the last page
+	next string
+	etag string
+	// notModified is set when the page matched the If-None-Match etag
+	notModified bool
+}
+
+// githubReleasesPage fetches a single page of releases, sending ifNoneMatch as a conditional etag if set
+func githubReleasesPage(ctx context.Context, pageURL string, ifNoneMatch string) (*releasesPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+githubToken)
+	}
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	resp, err := doWithRetry(githubClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("get releases %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &releasesPage{notModified: true}, nil
+	}
+	if resp.StatusCode == http.StatusForbidden &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return nil, newRateLimitError(resp.Header)
+	}
+
+	// read resp body to buf
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read releases %s: %w", pageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("get releases %s: unexpected status %s: %s", pageURL, resp.Status, bodySnippet(data))
+		message := githubErrorMessage(data)
+		if message != "" {
+			err = fmt.Errorf("get releases %s: %s: github said: %s", pageURL, resp.Status, message)
+		}
+		if resp.StatusCode >= 500 {
+			return nil, &githubServerError{err: err}
+		}
+		return nil, err
+	}
+
+	payloads, err := decodeReleases(data)
+	if err != nil {
+		return nil, fmt.Errorf("get releases %s: %s: %w: %s", pageURL, resp.Status, err, bodySnippet(data))
+	}
+
+	return &releasesPage{
+		releases: payloads,
+		next:     nextPageURL(resp.Header.Get("Link")),
+		etag:     resp.Header.Get("ETag"),
+	}, nil
+}
+
+// errReleaseNotFound is returned by getGithubRelease when GitHub responds 404
+var errReleaseNotFound = errors.New("release not found")
+
+// githubRelease fetches the release of repo tagged tag
+func githubRelease(ctx context.Context, repo string, tag string) (*release.Release, error) {
+	rel, err := getGithubRelease(ctx, githubAPIBase+"/repos/"+repo+"/releases/tags/"+url.PathEscape(tag))
+	if errors.Is(err, errReleaseNotFound) {
+		return nil, fmt.Errorf("get release: no release tagged %s in %s", tag, repo)
+	}
+	return rel, err
+}
+
+// githubLatestRelease fetches the release GitHub designates as latest in repo, which excludes
+// drafts and prereleases and can be set by hand. It returns nil if repo has no latest release.
+func githubLatestRelease(ctx context.Context, repo string) (*release.Release, error) {
+	rel, err := getGithubRelease(ctx, githubAPIBase+"/repos/"+repo+"/releases/latest")
+	if errors.Is(err, errReleaseNotFound) {
+		return nil, nil
+	}
+	return rel, err
+}
+
+// getGithubRelease fetches and decodes a single release from releaseURL
+func getGithubRelease(ctx context.Context, releaseURL string) (*release.Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if githubToken != "" {
+		req.Header.Set("Authorization", "Bearer "+githubToken)
+	}
+
+	resp, err := doWithRetry(githubClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("get release %s: %w", releaseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return nil, newRateLimitError(resp.Header)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errReleaseNotFound
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read release %s: %w", releaseURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := githubErrorMessage(data)
+		if message != "" {
+			return nil, fmt.Errorf("get release %s: %s: github said: %s", releaseURL, resp.Status, message)
+		}
+		return nil, fmt.Errorf("get release %s: unexpected status %s: %s", releaseURL, resp.Status, bodySnippet(data))
+	}
+
+	payload := &release.Release{}
+	err = json.Unmarshal(data, payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode release %s: %w: %s", releaseURL, err, bodySnippet(data))
+	}
+	return payload, nil
+}
+
+// releasesFromFile decodes a captured releases payload, as returned by the releases
+// endpoint, so a snapshot selects exactly as the live fetch did
+func releasesFromFile(path string) ([]*release.Release, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read releases file: %w", err)
+	}
+	releases, err := decodeReleases(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return releases, nil
+}
+
+// uniqueTags drops releases sharing a tag with a more recently published one, which happens
+// when a deleted tag is re-created, so selection and crash report lookups see one release per tag
+func uniqueTags(releases []*release.Release) []*release.Release {
+	unique := make([]*release.Release, 0, len(releases))
+	index := map[string]int{}
+	for _, rel := range releases {
+		i, ok := index[rel.TagName]
+		if !ok {
+			index[rel.TagName] = len(unique)
+			unique = append(unique, rel)
+			continue
+		}
+		kept, dropped := unique[i], rel
+		if publishedAfter(rel, unique[i]) {
+			kept, dropped = rel, unique[i]
+			unique[i] = rel
+		}
+		logger.Warn("duplicate release tag, using the most recently published",
+			"tag", rel.TagName, "published_at", kept.PublishedAt, "dropped_published_at", dropped.PublishedAt)
+	}
+	return unique
+}
+
+// publishedAfter reports whether a was published after b, a release with an unparsable date is never after
+func publishedAfter(a *release.Release, b *release.Release) bool {
+	aTime, err := release.ParseTimestamp(a.PublishedAt)
+	if err != nil {
+		return false
+	}
+	bTime, err := release.ParseTimestamp(b.PublishedAt)
+	if err != nil {
+		return true
+	}
+	return aTime.After(bTime)
+}
+
+// decodeReleases decodes a releases array, surfacing GitHub's message if it sent an error object instead
+func decodeReleases(data []byte) ([]*release.Release, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("decode releases: empty response body")
+	}
+	if trimmed[0] == '{' {
+		message := githubErrorMessage(trimmed)
+		if message == "" {
+			return nil, fmt.Errorf("decode releases: expected an array, got an object")
+		}
+		return nil, fmt.Errorf("decode releases: github said: %s", message)
+	}
+
+	payloads := []*release.Release{}
+	err := json.Unmarshal(trimmed, &payloads)
+	if err != nil {
+		return nil, fmt.Errorf("decode releases: %w", err)
+	}
+	for _, rel := range payloads {
+		rel.Body = truncateBody(rel.Body, maxBodyBytes)
+	}
+	return payloads, nil
+}
+
+// truncateBody returns the first max bytes of body, cut at a character boundary, or body if
+// max is 0. The result is copied so the whole body can be freed along with the page it came from.
+func truncateBody(body string, max int) string {
+	if max <= 0 || len(body) <= max {
+		return body
+	}
+	for max > 0 && !utf8.RuneStart(body[max]) {
+		max--
+	}
+	return strings.Clone(body[:max])
+}
+
+// githubErrorMessage returns the message of a GitHub error object, or empty if data isn't one
+func githubErrorMessage(data []byte) string {
+	type githubErrorJson struct {
+		Message string `json:"message"`
+	}
+
+	payload := &githubErrorJson{}
+	err := json.Unmarshal(data, payload)
+	if err != nil {
+		return ""
+	}
+	return payload.Message
+}
+
+// nextPageURL returns the rel="next" url from a GitHub Link header, or empty if there is none
+func nextPageURL(link string) string {
+	// Link: <https://api.github.com/...&page=2>; rel="next", <https://api.github.com/...&page=5>; rel="last"
+	for _, part := range strings.Split(link, ",") {
+		segments := strings.Split(part, ";")
+		if len(segments) < 2 {
+			continue
+		}
+		target := strings.TrimSpace(segments[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range segments[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+			}
+		}
+	}
+	return ""
+}
+
+// newRateLimitError builds a rateLimitError from GitHub rate limit headers
+func newRateLimitError(header http.Header) *rateLimitError {
+	rateErr := &rateLimitError{}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err == nil {
+		rateErr.Reset = time.Unix(reset, 0)
+	}
+	return rateErr
+}
@@ -1,3 +1,8 @@
 module github.com/eqemu-pack/server
 
 go 1.21.1
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// client is used for downloads, checksums and metrics
+	client *http.Client
+	// githubClient is used for GitHub API requests
+	githubClient *http.Client
+	// crashClient is used for Spire crash report requests
+	crashClient *http.Client
+	// retryAttempts is how many times a request is attempted before giving up
+	retryAttempts = 3
+	// retryDelay is the delay before the first retry, doubling each attempt
+	retryDelay = 500 * time.Millisecond
+	// maxRetryAfter caps the wait a Retry-After header asks for
+	maxRetryAfter = 60 * time.Second
+)
+
+// defaultUserAgent returns eqemu-pack-server/<version>, with the version from the build if known
+func defaultUserAgent() string {
+	return "eqemu-pack-server/" + currentBuild().Version
+}
+
+// userAgentTransport is an http.RoundTripper setting the User-Agent of every request
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper mustn't modify the request it's given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// tlsVersions are the values accepted by -tls-min-version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version named by value, e.g. 1.2, or 0 for Go's default if value is empty
+func parseTLSVersion(value string) (uint16, error) {
+	if value == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls-min-version %q, expected 1.0, 1.1, 1.2 or 1.3", value)
+	}
+	return version, nil
+}
+
+// newTransport returns an http transport honoring HTTP_PROXY/HTTPS_PROXY,
+// trusting the certificates in caCert in addition to the system roots if set.
+// minVersion is the lowest TLS version negotiated, 0 for Go's default, and insecureSkipVerify
+// accepts any server certificate, which is only meant for testing against staging servers.
+func newTransport(caCert string, minVersion uint16, insecureSkipVerify bool) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = &tls.Config{MinVersion: minVersion, InsecureSkipVerify: insecureSkipVerify}
+	if caCert == "" {
+		return transport, nil
+	}
+
+	pem, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("read ca cert: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ca cert %s: no certificates found", caCert)
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	return transport, nil
+}
+
+// requestCounter is an http.RoundTripper counting the requests sent through it, retries
+// included, and keeping the last GitHub rate limit remaining header it saw
+type requestCounter struct {
+	next     http.RoundTripper
+	requests atomic.Int64
+	// rateLimitRemaining is a string, empty until a response carries the header
+	rateLimitRemaining atomic.Value
+}
+
+func (c *requestCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	resp, err := c.next.RoundTrip(req)
+	if err == nil && resp.Header.Get("X-RateLimit-Remaining") != "" {
+		c.rateLimitRemaining.Store(resp.Header.Get("X-RateLimit-Remaining"))
+	}
+	return resp, err
+}
+
+// remaining returns the last rate limit remaining header seen, or "unknown"
+func (c *requestCounter) remaining() string {
+	remaining, ok := c.rateLimitRemaining.Load().(string)
+	if !ok {
+		return "unknown"
+	}
+	return remaining
+}
+
+// bufferBody reads resp's body into memory, replacing it with the buffered copy, and
+// reports whether it was empty or only whitespace
+func bufferBody(resp *http.Response) (bool, error) {
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, fmt.Errorf("read body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return len(bytes.TrimSpace(data)) == 0, nil
+}
+
+// maxErrorBody is how much of a response body is included in an error
+const maxErrorBody = 200
+
+// bodySnippet returns the start of a response body for an error message
+func bodySnippet(data []byte) string {
+	snippet := strings.TrimSpace(string(data))
+	if len(snippet) > maxErrorBody {
+		snippet = snippet[:maxErrorBody] + "..."
+	}
+	return snippet
+}
+
+// retryAfter returns the wait a 403 or 429 response's Retry-After header asks for, in seconds
+// or as an HTTP date, capped at maxRetryAfter. ok is false if resp isn't a secondary rate limit.
+func retryAfter(resp *http.Response) (wait time.Duration, ok bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else {
+		date, err := http.ParseTime(value)
+		if err != nil {
+			return 0, false
+		}
+		wait = time.Until(date)
+	}
+	return min(max(wait, 0), maxRetryAfter), true
+}
+
+// doWithRetry sends req with c, retrying network errors, 5xx responses and 200 responses with
+// an empty body, which edge caches occasionally serve, with exponential backoff.
+// 403 and 429 responses with a Retry-After header, GitHub's secondary rate limit, are retried
+// after the wait it asks for. Other 4xx responses are returned as is since retrying them won't help.
+func doWithRetry(c *http.Client, req *http.Request) (*http.Response, error) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := c.Do(req)
+		empty := false
+		if err == nil && resp.StatusCode == http.StatusOK {
+			empty, err = bufferBody(resp)
+		}
+		wait, limited := time.Duration(0), false
+		if err == nil {
+			wait, limited = retryAfter(resp)
+		}
+		if err == nil && resp.StatusCode < 500 && !empty && !limited {
+			return resp, nil
+		}
+		if attempt >= retryAttempts {
+			return resp, err
+		}
+		sleep := delay
+		if limited {
+			sleep = wait
+			logger.Warn("request hit a secondary rate limit, retrying", "url", req.URL.String(), "status", resp.Status, "attempt", attempt, "attempts", retryAttempts, "retry_after", wait)
+			resp.Body.Close()
+		} else if empty {
+			logger.Warn("request returned an empty body, retrying", "url", req.URL.String(), "attempt", attempt, "attempts", retryAttempts, "delay", delay)
+		} else if err != nil {
+			logger.Warn("request failed, retrying", "url", req.URL.String(), "attempt", attempt, "attempts", retryAttempts, "err", err, "delay", delay)
+		} else {
+			logger.Warn("request returned server error, retrying", "url", req.URL.String(), "status", resp.Status, "attempt", attempt, "attempts", retryAttempts, "delay", delay)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(sleep):
+		}
+		delay *= 2
+	}
+}
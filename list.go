@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// listEntryJson is a release and its stable gates as written by list -format json
+type listEntryJson struct {
+	Tag         string         `json:"tag"`
+	PublishedAt string         `json:"published_at"`
+	AgeHours    int            `json:"age_hours"`
+	Prerelease  bool           `json:"prerelease"`
+	Gates       []listGateJson `json:"gates"`
+	// Crashes is how many distinct servers reported crashes, nil unless crash counts were fetched
+	Crashes *int `json:"crashes"`
+	// Qualifies is set when every gate passed
+	Qualifies bool `json:"qualifies"`
+}
+
+type listGateJson struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// runList writes every release of repo with whether it passes each stable gate to w, as a
+// table or as json when format is json. Crash and adoption counts are only fetched with withCrash.
+// Gates comparing releases, like the minimum gap, aren't run, and nothing is written to disk.
+func runList(ctx context.Context, opts *options, rules release.Options, w io.Writer) error {
+	var releases []*release.Release
+	var err error
+	if opts.releasesFile != "" {
+		releases, err = releasesFromFile(opts.releasesFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		releases, err = githubReleases(ctx, opts.repo, nil)
+		if err != nil {
+			return &release.StageError{Stage: release.ErrGitHubFetch, Err: fmt.Errorf("githubReleases: %w", err)}
+		}
+	}
+	releases = uniqueTags(releases)
+
+	// CheckRelease asks for one count per release, so the last one belongs to the current release
+	var crashes *int
+	crashCount := rules.CrashCount
+	if !opts.withCrash {
+		crashCount = nil
+		rules.AdoptionCount = nil
+	}
+	rules.CrashCount = nil
+	if crashCount != nil {
+		rules.CrashCount = func(version string) (int, error) {
+			count, err := crashCount(version)
+			crashes = &count
+			return count, err
+		}
+	}
+
+	entries := []*listEntryJson{}
+	for _, rel := range releases {
+		crashes = nil
+		gates, err := release.CheckRelease(rel, rules)
+		if err != nil {
+			return fmt.Errorf("check %s: %w", rel.TagName, err)
+		}
+		entry := &listEntryJson{
+			Tag:         rel.TagName,
+			PublishedAt: rel.PublishedAt,
+			Prerelease:  rel.Prerelease,
+			Crashes:     crashes,
+			Qualifies:   true,
+		}
+		publishedAt, err := release.ParseTimestamp(rel.PublishedAt)
+		if err == nil {
+			entry.AgeHours = int(rules.CurrentTime().Sub(publishedAt).Hours())
+		}
+		for _, gate := range gates {
+			entry.Gates = append(entry.Gates, listGateJson{Name: gate.Name, Passed: gate.Passed, Detail: gate.Detail})
+			entry.Qualifies = entry.Qualifies && gate.Passed
+		}
+		entries = append(entries, entry)
+	}
+
+	if opts.format == "json" {
+		data, err := marshalOutput(entries, opts.jsonPretty)
+		if err != nil {
+			return fmt.Errorf("marshal releases: %w", err)
+		}
+		if !opts.jsonPretty {
+			data = append(data, '\n')
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return fmt.Errorf("write releases: %w", err)
+		}
+		return nil
+	}
+	return writeListTable(w, entries)
+}
+
+// writeListTable writes one row per release, with a pass or FAIL column per gate
+func writeListTable(w io.Writer, entries []*listEntryJson) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"TAG", "PUBLISHED", "AGE", "PRERELEASE"}
+	if len(entries) > 0 {
+		for _, gate := range entries[0].Gates {
+			header = append(header, strings.ToUpper(gate.Name))
+		}
+	}
+	header = append(header, "CRASHES", "QUALIFIES")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, entry := range entries {
+		published, _, _ := strings.Cut(entry.PublishedAt, "T")
+		row := []string{entry.Tag, published, fmt.Sprintf("%dd", entry.AgeHours/24), fmt.Sprint(entry.Prerelease)}
+		for _, gate := range entry.Gates {
+			result := "pass"
+			if !gate.Passed {
+				result = "FAIL"
+			}
+			row = append(row, result)
+		}
+		crashes := "-"
+		if entry.Crashes != nil {
+			crashes = fmt.Sprint(*entry.Crashes)
+		}
+		row = append(row, crashes, fmt.Sprint(entry.Qualifies))
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return fmt.Errorf("write releases: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logger receives all output other than the selected files
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// newLogger returns a logger writing format, text or json, to w.
+// Records below level are dropped, errors are always written.
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		// drop the time so text output stays easy to read, CI logs already carry timestamps
+		handlerOpts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		}
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+}
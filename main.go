@@ -1,184 +1,1378 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/eqemu-pack/server/release"
 )
 
-type releaseJson struct {
-	Name        string `json:"name"`
-	TagName     string `json:"tag_name"`
-	PublishedAt string `json:"published_at"`
-	Prerelease  bool   `json:"prerelease"`
-	Body        string `json:"body"`
+// options holds the command line configuration for a run.
+type options struct {
+	// config is a path to a YAML or TOML file of flag values, flags given on the command line win
+	config string
+	// minAge is how old a release must be before it can be stable
+	minAge string
+	// fallbackAge is how old a release must be to be used as a fallback
+	fallbackAge string
+	// minGap is how far apart releases must be published to be considered
+	minGap string
+	// gapBehavior is skip or collapse, how releases published within minGap of each other are handled
+	gapBehavior string
+	// retries is how many times a request is attempted before giving up
+	retries int
+	// retryDelay is the delay before the first retry, doubling each attempt
+	retryDelay string
+	// maxRetryAfter caps how long a Retry-After header can make a retry wait
+	maxRetryAfter string
+	// since is a YYYY-MM-DD date releases published before are ignored, empty considers every release
+	since string
+	// timeout bounds the whole run, 0 means no limit
+	timeout string
+	// startupJitter is the most a run sleeps for before making any requests, 0 doesn't sleep
+	startupJitter string
+	// githubTimeout bounds each GitHub API request, 0 means no limit
+	githubTimeout string
+	// crashTimeout bounds each crash report request, 0 means no limit
+	crashTimeout string
+	// keywords are matched case-insensitively against a release body, an empty keyword disables the check
+	keywords stringList
+	// force writes output files even when their contents are unchanged
+	force bool
+	// failOnFallback exits with exitFallback when the fallback release is used as stable
+	failOnFallback bool
+	// failOnRegression exits with exitRegression when stable is older than the last run's
+	failOnRegression bool
+	// dryRun prints the selection without writing any files
+	dryRun bool
+	// format is the output format, txt, json or env
+	format string
+	// envFiles also writes the txt files when using -format env
+	envFiles bool
+	// repo is the owner/name of the GitHub repository to select releases from
+	repo string
+	// reposFile lists owner/name repositories, one per line, to select releases for in one run
+	reposFile string
+	// skipCrashCheck treats every release as having no crash reports
+	skipCrashCheck bool
+	// crashCheckSoftFail skips candidates whose crash reports can't be fetched instead of failing the run
+	crashCheckSoftFail bool
+	// crashDedupeKey is the crash report field distinct servers are counted by, name or shortname
+	crashDedupeKey string
+	// crashWindow is how recent a crash report must be to be counted, 0 counts every report
+	crashWindow string
+	// crashNoCache sends Cache-Control: no-cache on crash report requests
+	crashNoCache bool
+	// minAdoption is how many distinct servers adoptionURL must list as running a release before it can be stable
+	minAdoption int
+	// adoptionURL is the endpoint listing the servers running a version
+	adoptionURL string
+	// policies is a comma separated chain of the built-in gates a stable candidate must pass
+	policies string
+	// bodyRule is the expression the body policy matches against a release body
+	bodyRule string
+	// trailCount selects stable as the release this many versions behind the latest instead of by age
+	trailCount int
+	// maxCandidates is how many stable candidates are crash checked before using the fallback, 0 means no limit
+	maxCandidates int
+	// stableCount is how many stable candidates passing every gate are selected for canary rollouts
+	stableCount int
+	// selectionMode is first or score, how stable is picked among the candidates passing every gate
+	selectionMode string
+	// scoreRecency, scoreHalfLife, scoreCrashes and scoreFixes are the score weights
+	scoreRecency  float64
+	scoreHalfLife string
+	scoreCrashes  float64
+	scoreFixes    float64
+	// crashPrefetch is how many stable candidates have their crash reports fetched concurrently
+	crashPrefetch int
+	// maxCrashServers is how many distinct servers may report crashes before a release is rejected
+	maxCrashServers int
+	// allowPrereleaseUnstable lets prereleases be selected for the unstable channel, never for stable
+	allowPrereleaseUnstable bool
+	// unstableSource is release or prerelease, whether the unstable channel is the newest full release or prerelease
+	unstableSource string
+	// preferGithubLatest uses the release GitHub designates as latest for the unstable channel
+	preferGithubLatest bool
+	// allowNonSemver allows tags that don't look like vMAJOR.MINOR.PATCH
+	allowNonSemver bool
+	// tagPrefix skips releases whose tag doesn't start with it
+	tagPrefix string
+	// auditFile is a path to append json lines describing each release decision to
+	auditFile string
+	// outDir is the directory output files are written to
+	outDir string
+	// output is files to write the results to outDir, or stdout to print them without touching disk
+	output string
+	// latestFile is the name of the file the latest release tag is written to
+	latestFile string
+	// stableFile is the name of the file the stable release tag is written to
+	stableFile string
+	// selectBy is tag or name, which field of the selected releases the txt files contain
+	selectBy string
+	// emitPrerelease also writes the newest prerelease tag to prerelease.txt
+	emitPrerelease bool
+	// stableJSON also writes the full stable release metadata to stable.json
+	stableJSON bool
+	// bleedingFile is the name of the file the newest prerelease tag is written to
+	bleedingFile string
+	// channels is a comma separated list of the channels to select and write
+	channels string
+	// channelRules overrides the gates of individual channels
+	channelRules channelRulesFlag
+	// releasesFile is a captured releases payload to read instead of fetching from GitHub
+	releasesFile string
+	// githubAPIBase is the GitHub API url, for GitHub Enterprise installs
+	githubAPIBase string
+	// crashAPIBase is the crash report endpoint, for servers running their own analytics
+	crashAPIBase string
+	// caCert is a path to a PEM file of extra root certificates to trust
+	caCert string
+	// tlsMinVersion is the lowest TLS version negotiated, e.g. 1.2, empty for Go's default
+	tlsMinVersion string
+	// insecureSkipTLSVerify accepts any crash report server certificate, only for testing
+	insecureSkipTLSVerify bool
+	// noCache ignores the cached releases listing and always fetches a fresh copy
+	noCache bool
+	// noStale fails when GitHub returns a 5xx instead of using the cached releases listing
+	noStale bool
+	// minFixes is how many lines of a release body must contain a keyword for it to be stable
+	minFixes int
+	// minBodyLength is how many characters a release body must have for it to be stable
+	minBodyLength int
+	// maxBodyKB truncates release bodies to this many KB as they're decoded, 0 keeps them whole
+	maxBodyKB int
+	// requireAssets are globs a stable release must have a matching asset for each of
+	requireAssets stringList
+	// download saves the assets of the stable release to the out dir
+	download bool
+	// assetPattern is a glob limiting which assets are downloaded
+	assetPattern string
+	// downloadSource is tar or zip to download the source archive of the stable release
+	downloadSource string
+	// requireChecksums fails downloads that can't be verified against published checksums
+	requireChecksums bool
+	// verbose prints each decision made along the way
+	verbose bool
+	// quiet prints nothing on success
+	quiet bool
+	// logFormat is text or json
+	logFormat string
+	// jsonPretty indents json outputs for readable diffs
+	jsonPretty bool
+	// userAgent is sent with every request, empty uses eqemu-pack-server/<version>
+	userAgent string
+	// record is a directory GitHub and Spire responses are saved to as test fixtures
+	record string
+	// tokenFile is a file holding the GitHub token, used instead of GITHUB_TOKEN
+	tokenFile string
+	// checkTag is set by the check subcommand to evaluate a single tag instead of selecting releases
+	checkTag string
+	// diff is set by the diff subcommand to print how the output files would change instead of writing them
+	diff bool
+	// ping is set by the ping subcommand to check GitHub and Spire are reachable instead of selecting releases
+	ping bool
+	// pinStable is a tag written as stable without running the gates
+	pinStable string
+	// promote is set by the promote subcommand to advance stable at most one release past the current stable.txt
+	promote bool
+	// list is set by the list subcommand to print every release with its gates instead of selecting releases
+	list bool
+	// withCrash fetches crash counts for the list subcommand
+	withCrash bool
+	// notifyWebhook is a url a JSON payload is posted to when stable changes
+	notifyWebhook string
+	// metricsPush is a Prometheus Pushgateway group url to push run metrics to
+	metricsPush string
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-var (
-	client *http.Client
+// exit codes, see README.md
+const (
+	// exitOK means releases were selected
+	exitOK = 0
+	// exitError means the run failed, e.g. a network error, and may be retried
+	exitError = 1
+	// exitNoRelease means no release qualified as stable and there was no fallback
+	exitNoRelease = 2
+	// exitFallback means the fallback release was used as stable with -fail-on-fallback,
+	// the output files are still written
+	exitFallback = 3
+	// exitRegression means stable is an older version than the last run's with
+	// -fail-on-regression, the output files are still written
+	exitRegression = 4
 )
 
+// errUsedFallback is returned by run when the fallback release was used and failOnFallback is set
+var errUsedFallback = errors.New("fallback release used")
+
 func main() {
-	err := run()
+	opts := &options{}
+	flag.StringVar(&opts.config, "config", "", "YAML or TOML file of flag values keyed by flag name, flags given on the command line override it")
+	flag.StringVar(&opts.minAge, "min-age", "168h", "minimum age of a release before it is considered stable")
+	flag.StringVar(&opts.fallbackAge, "fallback-age", "720h", "minimum age of a release before it is used as a fallback")
+	flag.StringVar(&opts.minGap, "min-gap", "72h", "minimum time between releases before a release is considered")
+	flag.StringVar(&opts.gapBehavior, "gap-behavior", "skip", "how releases within -min-gap of each other are handled: skip considers only the newest, collapse considers the highest version passing the gates")
+	flag.IntVar(&opts.retries, "retries", 3, "number of attempts for each http request")
+	flag.StringVar(&opts.retryDelay, "retry-delay", "500ms", "delay before the first retry, doubled for each further retry")
+	flag.StringVar(&opts.maxRetryAfter, "max-retry-after", "60s", "longest wait honored from a Retry-After header on a GitHub secondary rate limit")
+	flag.StringVar(&opts.since, "since", "", "ignore releases published before this YYYY-MM-DD date, including as a fallback")
+	flag.StringVar(&opts.timeout, "timeout", "0s", "overall deadline for the run, 0 means no limit")
+	flag.StringVar(&opts.startupJitter, "startup-jitter", "0s", "sleep a random duration up to this before making any requests, to spread out runs started together by cron")
+	flag.StringVar(&opts.githubTimeout, "github-timeout", "10s", "timeout for each GitHub API request, 0 means no timeout")
+	flag.StringVar(&opts.crashTimeout, "crash-timeout", "10s", "timeout for each crash report request, 0 means no timeout")
+	flag.Var(&opts.keywords, "require-keyword", "keyword a release body must contain to be stable, matched case-insensitively (repeatable, default \"fix\", an empty keyword disables the check)")
+	flag.BoolVar(&opts.force, "force", false, "write output files even when the selected tags are unchanged")
+	flag.BoolVar(&opts.failOnRegression, "fail-on-regression", false, "exit with status 4 when the stable release is an older version than the one chosen by the last run")
+	flag.BoolVar(&opts.failOnFallback, "fail-on-fallback", false, "exit with status 3 when the fallback release is used as stable")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "print the selected releases without writing any files")
+	flag.StringVar(&opts.format, "format", "txt", "output format, txt writes the latest and stable files, json writes selection.json, env prints shell assignments like EQEMU_STABLE=v1.2.3 to stdout")
+	flag.BoolVar(&opts.envFiles, "env-files", false, "with -format env, also write the txt files")
+	flag.StringVar(&opts.repo, "repo", "eqemu/server", "GitHub repository to select releases from, as owner/name")
+	flag.StringVar(&opts.reposFile, "repos-file", "", "file of owner/name repositories, one per line, to select releases for instead of -repo, writing each under out-dir/owner/name")
+	flag.BoolVar(&opts.skipCrashCheck, "skip-crash-check", false, "don't query crash reports, treating every release as having none")
+	flag.BoolVar(&opts.crashCheckSoftFail, "crash-check-soft-fail", false, "treat a failed crash report fetch as an unknown count and skip that release rather than failing the run")
+	flag.StringVar(&opts.crashWindow, "crash-window", "0s", "only count crash reports newer than this, e.g. 720h, 0 counts every report")
+	flag.BoolVar(&opts.crashNoCache, "crash-no-cache", false, "send Cache-Control: no-cache on crash report requests so a CDN in front of Spire can't serve a stale count")
+	flag.StringVar(&opts.crashDedupeKey, "crash-dedupe-key", "name", "crash report field distinct servers are counted by, name or shortname")
+	flag.IntVar(&opts.minAdoption, "min-adoption", 0, "require at least this many distinct servers listed by -adoption-url as running a release before it can be stable, 0 disables it")
+	flag.StringVar(&opts.adoptionURL, "adoption-url", "", "endpoint listing the servers running a version, asked with a version query parameter and returning Spire's crash report schema, one entry per server")
+	flag.StringVar(&opts.policies, "policies", "age,keywords", "comma separated gates a stable candidate must pass in order, age uses -min-age, keywords uses -require-keyword and -min-fixes, and body uses -body-rule")
+	flag.StringVar(&opts.bodyRule, "body-rule", `"Fix"`, `expression a release body must match for the body policy, requiring body in -policies, quoted substrings combined with AND, OR, NOT and parentheses, e.g. '"Fix" AND NOT "BREAKING"'`)
+	flag.IntVar(&opts.trailCount, "trail-count", 0, "select stable as the release this many versions behind the latest, subject to the crash gate, instead of using -min-age, -min-gap and -min-fixes")
+	flag.IntVar(&opts.maxCandidates, "max-candidates", 0, "crash check at most this many stable candidates before using the fallback, 0 means no limit")
+	flag.IntVar(&opts.stableCount, "stable-count", 1, "select this many stable candidates passing every gate for canary rollouts, in order of preference; stable.txt has the first and stable-candidates.txt lists them all")
+	weights := release.DefaultScoreWeights()
+	flag.StringVar(&opts.selectionMode, "selection-mode", "first", "how stable is picked among candidates passing every gate: first takes the highest version, score crash checks them all and takes the highest score")
+	flag.Float64Var(&opts.scoreRecency, "score-recency", weights.Recency, "score of a release published now with -selection-mode score, halving every -score-half-life")
+	flag.StringVar(&opts.scoreHalfLife, "score-half-life", "336h", "how long it takes the recency score to halve")
+	flag.Float64Var(&opts.scoreCrashes, "score-crashes", weights.Crashes, "score subtracted per distinct server reporting crashes")
+	flag.Float64Var(&opts.scoreFixes, "score-fixes", weights.Fixes, "score added per release body line containing a -require-keyword")
+	flag.IntVar(&opts.crashPrefetch, "crash-prefetch", 4, "fetch crash reports for this many of the top stable candidates concurrently, 1 fetches them one at a time")
+	flag.IntVar(&opts.maxCrashServers, "max-crash-servers", 0, "reject a stable candidate when more than this many distinct servers reported crashes")
+	flag.BoolVar(&opts.allowPrereleaseUnstable, "allow-prerelease-unstable", false, "let prereleases be written to latest.txt, stable never includes them")
+	flag.StringVar(&opts.unstableSource, "unstable-source", "release", "what latest.txt is selected from: release for the highest full release, prerelease for the highest prerelease, falling back to the highest release if there are none")
+	flag.BoolVar(&opts.preferGithubLatest, "prefer-github-latest", false, "write the release GitHub designates as latest to latest.txt when it disagrees with the highest version")
+	flag.StringVar(&opts.tagPrefix, "tag-prefix", "", "only consider releases whose tag starts with this prefix, e.g. \"server-\", stripped before the tag is parsed as a version")
+	flag.BoolVar(&opts.allowNonSemver, "allow-nonsemver", false, "allow release tags that don't look like vMAJOR.MINOR.PATCH")
+	flag.StringVar(&opts.auditFile, "audit-file", "", "append a json line per considered release with the reason it was skipped or selected")
+	flag.StringVar(&opts.outDir, "out-dir", "bin", "directory to write output files to")
+	flag.StringVar(&opts.output, "output", "files", "files writes the results to -out-dir, stdout prints them in the chosen format without creating any files")
+	flag.StringVar(&opts.latestFile, "latest-file", "latest.txt", "name of the file the latest release tag is written to")
+	flag.StringVar(&opts.stableFile, "stable-file", "stable.txt", "name of the file the stable release tag is written to")
+	flag.StringVar(&opts.selectBy, "select-by", "tag", "what the txt files contain for each selected release: tag for its tag, name for its release name, falling back to the tag when the name is blank")
+	flag.BoolVar(&opts.emitPrerelease, "emit-prerelease", false, "also write the newest prerelease by publish date to prerelease.txt, without any stable gates")
+	flag.BoolVar(&opts.stableJSON, "stable-json", false, "also write the full stable release, including its name, publish date and body, to stable.json")
+	flag.StringVar(&opts.bleedingFile, "bleeding-file", "bleeding.txt", "name of the file the newest prerelease tag is written to")
+	flag.StringVar(&opts.channels, "channels", "stable,unstable", "comma separated channels to select and write, any of stable, unstable and bleeding")
+	opts.channelRules = channelRulesFlag{}
+	flag.Var(opts.channelRules, "channel-rule", "override one gate of a channel as channel.key=value, e.g. unstable.min-age=24h, with key min-age, min-gap, fallback-age, require-keyword, min-fixes, max-crash-servers or crash-check (repeatable)")
+	flag.StringVar(&opts.releasesFile, "releases-file", "", "read releases from this captured GitHub releases json instead of fetching them, for offline runs and replaying snapshots")
+	flag.StringVar(&opts.githubAPIBase, "github-api-base", githubAPIBase, "GitHub API base url, e.g. https://ghe.example.com/api/v3 for GitHub Enterprise")
+	flag.StringVar(&opts.crashAPIBase, "crash-api-base", crashReportURL, "crash report endpoint url, a version query parameter is added to any it already has, for analytics servers sharing Spire's schema")
+	flag.StringVar(&opts.caCert, "ca-cert", "", "path to a PEM file of extra root certificates to trust, e.g. for a corporate proxy")
+	flag.StringVar(&opts.tlsMinVersion, "tls-min-version", "", "lowest TLS version to negotiate: 1.0, 1.1, 1.2 or 1.3, defaults to Go's minimum of 1.2")
+	flag.BoolVar(&opts.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "UNSAFE, for testing only: accept any certificate from the crash report and adoption servers, e.g. a staging Spire's self-signed one, leaving crash counts open to tampering. GitHub and downloads are still verified. Prefer -ca-cert")
+	flag.BoolVar(&opts.noStale, "no-stale", false, "fail when GitHub returns a 5xx instead of using the cached releases listing")
+	flag.BoolVar(&opts.noCache, "no-cache", false, "ignore the cached releases listing in the out dir and fetch a fresh copy")
+	flag.IntVar(&opts.minFixes, "min-fixes", 1, "minimum number of release body lines containing a -require-keyword for a release to be stable")
+	flag.Var(&opts.requireAssets, "require-asset", "glob a stable candidate must have a matching asset name for, e.g. \"*linux*\" (repeatable)")
+	flag.IntVar(&opts.minBodyLength, "min-body-length", 0, "reject a stable candidate whose release notes are shorter than this many characters, catching empty or placeholder bodies")
+	flag.IntVar(&opts.maxBodyKB, "max-body-kb", 0, "keep only the first this many KB of each release body to save memory, keywords past it aren't seen; ignored with download, json, stable-json and notify-webhook, which need whole bodies. 0 keeps whole bodies")
+	flag.BoolVar(&opts.download, "download", false, "download the assets of the stable release to the out dir")
+	flag.StringVar(&opts.assetPattern, "asset-pattern", "", "only download assets whose name matches this glob, e.g. \"*linux*\"")
+	flag.StringVar(&opts.downloadSource, "download-source", "", "download the source archive of the stable release to the out dir, tar or zip")
+	flag.BoolVar(&opts.requireChecksums, "require-checksums", false, "fail when downloaded assets can't be verified against a checksums.txt or *.sha256 asset")
+	flag.BoolVar(&opts.verbose, "verbose", false, "print each release decision along with the selected releases")
+	flag.BoolVar(&opts.quiet, "quiet", false, "print nothing on success, errors are always printed")
+	flag.StringVar(&opts.logFormat, "log-format", "text", "log format, text or json")
+	flag.BoolVar(&opts.jsonPretty, "json-pretty", false, "indent json output files and list -format json for readable diffs")
+	flag.StringVar(&opts.userAgent, "user-agent", "", "User-Agent sent with every request (default eqemu-pack-server/<version>)")
+	flag.StringVar(&opts.record, "record", "", "save every GitHub and Spire response to this directory as a test fixture, e.g. testdata/replay")
+	flag.StringVar(&opts.tokenFile, "token-file", "", "read the GitHub token from this file, e.g. a mounted secret, instead of GITHUB_TOKEN")
+	flag.StringVar(&opts.pinStable, "pin-stable", "", "write this tag as stable without running the gates, e.g. during an incident, it must be a release")
+	flag.BoolVar(&opts.withCrash, "with-crash", false, "fetch crash counts, and adoption counts with -min-adoption, for each release listed by the list subcommand")
+	flag.StringVar(&opts.notifyWebhook, "notify-webhook", "", "post a JSON payload to this url, e.g. a Slack or Discord webhook, when the stable tag changes")
+	flag.StringVar(&opts.metricsPush, "metrics-push", "", "Prometheus Pushgateway group url to push run metrics to, e.g. http://pushgateway:9091/metrics/job/eqemu_release")
+	// flag errors exit with exitError rather than the flag package's 2, which means no release here
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && (args[0] == "check" || args[0] == "ping" || args[0] == "diff" || args[0] == "list" || args[0] == "promote" || args[0] == "version") {
+		command, args = args[0], args[1:]
+	}
+	err := flag.CommandLine.Parse(args)
+	if err == flag.ErrHelp {
+		os.Exit(exitOK)
+	}
 	if err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
-	os.Exit(0)
+	// version doesn't need any network or configuration
+	if command == "version" {
+		err = writeVersion(os.Stdout, opts.format == "json")
+		if err != nil {
+			logger.Error("version failed", "err", err)
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	}
+	if command == "check" {
+		if flag.NArg() != 1 {
+			logger.Error("usage: check [flags] <tag>", "args", flag.Args())
+			os.Exit(exitError)
+		}
+		opts.checkTag = flag.Arg(0)
+	}
+	if command == "ping" || command == "diff" || command == "list" || command == "promote" {
+		if flag.NArg() != 0 {
+			logger.Error("usage: "+command+" [flags]", "args", flag.Args())
+			os.Exit(exitError)
+		}
+		opts.ping = command == "ping"
+		opts.diff = command == "diff"
+		opts.list = command == "list"
+		opts.promote = command == "promote"
+	}
+	if opts.config != "" {
+		err = loadConfig(flag.CommandLine, opts.config)
+		if err != nil {
+			logger.Error("load config failed", "err", err)
+			os.Exit(exitError)
+		}
+	}
+	// -trail-count replaces the age gate, so giving both is a mistake, on the command line or
+	// in the config file
+	minAgeSet := false
+	bodyRuleSet := false
+	flag.Visit(func(f *flag.Flag) {
+		minAgeSet = minAgeSet || f.Name == "min-age"
+		bodyRuleSet = bodyRuleSet || f.Name == "body-rule"
+	})
+	if opts.trailCount > 0 && minAgeSet {
+		logger.Error("trail-count and min-age can't be used together")
+		os.Exit(exitError)
+	}
+	// -body-rule is only matched by the body policy, without it the rule would silently do nothing
+	if bodyRuleSet && !hasPolicy(opts.policies, "body") {
+		logger.Error("body-rule requires body in policies", "policies", opts.policies)
+		os.Exit(exitError)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	_, err = run(ctx, opts)
+	stop()
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit code for the error run returned, logging it where it hasn't been.
+// With repos-file err joins the errors of every repo, and any error outside the benign
+// classes makes it exitError, so a failed repo isn't hidden behind another without a release.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if !benignError(err) {
+		logger.Error("run failed", "err", err)
+		return exitError
+	}
+	// the diff has already been printed
+	if errors.Is(err, errChanged) {
+		return exitError
+	}
+	if errors.Is(err, release.ErrNoRelease) {
+		logger.Error("no suitable release", "err", err)
+		return exitNoRelease
+	}
+	// the regression and fallback warnings have already been logged
+	if errors.Is(err, errRegression) {
+		return exitRegression
+	}
+	return exitFallback
 }
 
-func run() error {
+// benignError reports whether err and every error joined into it is a changed diff, no release,
+// a regression or a used fallback, rather than a failure worth retrying
+func benignError(err error) bool {
+	if err == errChanged || err == release.ErrNoRelease || err == errRegression || err == errUsedFallback {
+		return true
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		for _, err := range errs {
+			if !benignError(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	case interface{ Unwrap() error }:
+		wrapped := e.Unwrap()
+		return wrapped != nil && benignError(wrapped)
+	}
+	return false
+}
+
+// run selects releases as configured by opts and writes them out, returning what was selected
+// for each repo. The result is nil for the ping, check and list subcommands.
+func run(ctx context.Context, opts *options) (*runResult, error) {
+	start := time.Now()
+	minAge, err := parseDuration("min-age", opts.minAge)
+	if err != nil {
+		return nil, err
+	}
+	fallbackAge, err := parseDuration("fallback-age", opts.fallbackAge)
+	if err != nil {
+		return nil, err
+	}
+	minGap, err := parseDuration("min-gap", opts.minGap)
+	if err != nil {
+		return nil, err
+	}
+	var selectionMode release.SelectionMode
+	switch opts.selectionMode {
+	case "first":
+		selectionMode = release.SelectFirst
+	case "score":
+		selectionMode = release.SelectScore
+	default:
+		return nil, fmt.Errorf("unknown selection-mode %q, expected first or score", opts.selectionMode)
+	}
+	scoreHalfLife, err := parseDuration("score-half-life", opts.scoreHalfLife)
+	if err != nil {
+		return nil, err
+	}
+	if selectionMode == release.SelectScore && opts.promote {
+		return nil, fmt.Errorf("promote advances stable one version at a time, so it can't be used with selection-mode score")
+	}
+	var gapBehavior release.GapBehavior
+	switch opts.gapBehavior {
+	case "skip":
+		gapBehavior = release.GapSkip
+	case "collapse":
+		gapBehavior = release.GapCollapse
+	default:
+		return nil, fmt.Errorf("unknown gap-behavior %q, expected skip or collapse", opts.gapBehavior)
+	}
+
+	since := time.Time{}
+	if opts.since != "" {
+		since, err = time.Parse(time.DateOnly, opts.since)
+		if err != nil {
+			return nil, fmt.Errorf("parse since: %w", err)
+		}
+	}
+
+	retryDelay, err = parseDuration("retry-delay", opts.retryDelay)
+	if err != nil {
+		return nil, err
+	}
+	maxRetryAfter, err = parseDuration("max-retry-after", opts.maxRetryAfter)
+	if err != nil {
+		return nil, err
+	}
+	if opts.retries < 1 {
+		return nil, fmt.Errorf("retries must be at least 1, got %d", opts.retries)
+	}
+	retryAttempts = opts.retries
+	if opts.verbose && opts.quiet {
+		return nil, fmt.Errorf("verbose and quiet can't be used together")
+	}
+	level := slog.LevelInfo
+	if opts.verbose {
+		level = slog.LevelDebug
+	}
+	if opts.quiet {
+		level = slog.LevelError
+	}
+	// env output is sourced by shells, so logs go to stderr rather than mixing in with it
+	logOutput := os.Stdout
+	if opts.format == "env" || opts.output == "stdout" {
+		logOutput = os.Stderr
+	}
+	logger, err = newLogger(logOutput, opts.logFormat, level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.minFixes < 0 {
+		return nil, fmt.Errorf("min-fixes must not be negative, got %d", opts.minFixes)
+	}
+	if opts.minBodyLength < 0 {
+		return nil, fmt.Errorf("min-body-length must not be negative, got %d", opts.minBodyLength)
+	}
+	if opts.maxBodyKB < 0 {
+		return nil, fmt.Errorf("max-body-kb must not be negative, got %d", opts.maxBodyKB)
+	}
+	maxBodyBytes = opts.maxBodyKB * 1024
+	if maxBodyBytes > 0 && (opts.download || opts.format == "json" || opts.stableJSON || opts.notifyWebhook != "") {
+		logger.Info("keeping whole release bodies, download, json, stable-json and notify-webhook need them", "max_body_kb", opts.maxBodyKB)
+		maxBodyBytes = 0
+	}
+	for _, pattern := range opts.requireAssets {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("require-asset %q: %w", pattern, err)
+		}
+	}
+	if opts.maxCrashServers < 0 {
+		return nil, fmt.Errorf("max-crash-servers must not be negative, got %d", opts.maxCrashServers)
+	}
+	if opts.minAdoption < 0 {
+		return nil, fmt.Errorf("min-adoption must not be negative, got %d", opts.minAdoption)
+	}
+	// crash reports only come from crashing servers, so adoption needs its own source
+	if opts.minAdoption > 0 && opts.adoptionURL == "" {
+		return nil, fmt.Errorf("min-adoption requires adoption-url")
+	}
+	if opts.trailCount < 0 {
+		return nil, fmt.Errorf("trail-count must not be negative, got %d", opts.trailCount)
+	}
+	if opts.maxCandidates < 0 {
+		return nil, fmt.Errorf("max-candidates must not be negative, got %d", opts.maxCandidates)
+	}
+	if opts.stableCount < 1 {
+		return nil, fmt.Errorf("stable-count must be at least 1, got %d", opts.stableCount)
+	}
+	if opts.crashPrefetch < 1 {
+		return nil, fmt.Errorf("crash-prefetch must be at least 1, got %d", opts.crashPrefetch)
+	}
+	if opts.crashDedupeKey != "name" && opts.crashDedupeKey != "shortname" {
+		return nil, fmt.Errorf("unknown crash-dedupe-key %q, expected name or shortname", opts.crashDedupeKey)
+	}
+	crashDedupeKey = opts.crashDedupeKey
+	crashNoCache = opts.crashNoCache
+	crashWindow, err = parseDuration("crash-window", opts.crashWindow)
+	if err != nil {
+		return nil, err
+	}
+	if opts.format != "txt" && opts.format != "json" && opts.format != "env" {
+		return nil, fmt.Errorf("unknown format %q, expected txt, json or env", opts.format)
+	}
+	err = validateRepo(opts.repo)
+	if err != nil {
+		return nil, err
+	}
+	if opts.unstableSource != "release" && opts.unstableSource != "prerelease" {
+		return nil, fmt.Errorf("unknown unstable-source %q, expected release or prerelease", opts.unstableSource)
+	}
+	if opts.unstableSource == "prerelease" && opts.allowPrereleaseUnstable {
+		return nil, fmt.Errorf("allow-prerelease-unstable and unstable-source prerelease can't be used together")
+	}
+	if opts.output != "files" && opts.output != "stdout" {
+		return nil, fmt.Errorf("unknown output %q, expected files or stdout", opts.output)
+	}
+	if opts.output == "stdout" && (opts.download || opts.downloadSource != "" || opts.stableJSON || opts.envFiles || opts.reposFile != "") {
+		return nil, fmt.Errorf("download, download-source, stable-json, env-files and repos-file write files, so they can't be used with output stdout")
+	}
+	// state.json is never updated, so every run would notify of the same change again
+	if opts.output == "stdout" && opts.notifyWebhook != "" {
+		return nil, fmt.Errorf("notify-webhook compares against state.json, which output stdout doesn't write, so they can't be used together")
+	}
+	if opts.promote && (opts.format == "json" || (opts.format == "env" && !opts.envFiles)) {
+		return nil, fmt.Errorf("promote reads the current stable from %s, so it needs the txt format or env-files", opts.stableFile)
+	}
+	if opts.selectBy != "tag" && opts.selectBy != "name" {
+		return nil, fmt.Errorf("unknown select-by %q, expected tag or name", opts.selectBy)
+	}
+	if opts.promote && opts.selectBy == "name" {
+		return nil, fmt.Errorf("promote reads the current stable tag from %s, so it can't be used with select-by name", opts.stableFile)
+	}
+	if opts.reposFile != "" && (opts.releasesFile != "" || opts.metricsPush != "") {
+		return nil, fmt.Errorf("repos-file can't be used with releases-file or metrics-push, which describe a single repo")
+	}
+	selectedChannels, err := parseChannels(opts.channels)
+	if err != nil {
+		return nil, err
+	}
+	// channels are in output order, so stable is first when it's selected
+	if selectedChannels[0].name != "stable" && (opts.download || opts.downloadSource != "" || opts.metricsPush != "" || opts.stableJSON || opts.pinStable != "" || opts.notifyWebhook != "") {
+		return nil, fmt.Errorf("download, download-source, metrics-push, stable-json, pin-stable and notify-webhook describe the stable release and need the stable channel")
+	}
+	if opts.pinStable != "" && opts.reposFile != "" {
+		return nil, fmt.Errorf("pin-stable can't be used with repos-file, which selects several repos")
+	}
+	for name := range opts.channelRules {
+		selected := false
+		for _, c := range selectedChannels {
+			selected = selected || c.name == name
+		}
+		if !selected {
+			return nil, fmt.Errorf("channel-rule for %s, which isn't one of the channels %q", name, opts.channels)
+		}
+	}
+	// -trail-count replaces the age gate, like it does for -min-age
+	if rules := opts.channelRules["stable"]; rules != nil && rules.minAge != nil && opts.trailCount > 0 {
+		return nil, fmt.Errorf("channel-rule stable.min-age can't be used with trail-count")
+	}
+	if opts.downloadSource != "" && opts.downloadSource != "tar" && opts.downloadSource != "zip" {
+		return nil, fmt.Errorf("invalid download-source %q, expected tar or zip", opts.downloadSource)
+	}
+	if opts.githubAPIBase != "" {
+		githubAPIBase = strings.TrimSuffix(opts.githubAPIBase, "/")
+	}
+	githubToken, err = readGithubToken(opts.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if opts.crashAPIBase != "" {
+		crashURL, err := url.Parse(opts.crashAPIBase)
+		if err != nil || (crashURL.Scheme != "http" && crashURL.Scheme != "https") || crashURL.Host == "" {
+			return nil, fmt.Errorf("invalid crash-api-base %q, expected an http or https url", opts.crashAPIBase)
+		}
+		crashReportURL = opts.crashAPIBase
+	}
+	if opts.adoptionURL != "" {
+		u, err := url.Parse(opts.adoptionURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid adoption-url %q, expected an http or https url", opts.adoptionURL)
+		}
+		adoptionURL = opts.adoptionURL
+	}
+	keywords := opts.keywords
+	timeout, err := parseDuration("timeout", opts.timeout)
+	if err != nil {
+		return nil, err
+	}
+	startupJitter, err := parseDuration("startup-jitter", opts.startupJitter)
+	if err != nil {
+		return nil, err
+	}
+	// spread out runs started at the same time by cron, before the timeout starts counting
+	if startupJitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(startupJitter) + 1))
+		logger.Debug("sleeping before starting", "delay", delay, "startup_jitter", startupJitter)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	githubTimeout, err := parseDuration("github-timeout", opts.githubTimeout)
+	if err != nil {
+		return nil, err
+	}
+	crashTimeout, err := parseDuration("crash-timeout", opts.crashTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsMinVersion, err := parseTLSVersion(opts.tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	baseTransport, err := newTransport(opts.caCert, tlsMinVersion, false)
+	if err != nil {
+		return nil, err
+	}
+	// skipping verification is limited to Spire requests, so the GitHub token and downloads
+	// never go over a connection whose certificate isn't checked
+	crashBaseTransport := baseTransport
+	if opts.insecureSkipTLSVerify {
+		logger.Warn("TLS CERTIFICATE VERIFICATION IS DISABLED for crash report requests, only use insecure-skip-tls-verify for testing")
+		crashBaseTransport, err = newTransport(opts.caCert, tlsMinVersion, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	userAgent := opts.userAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent()
+	}
+	transport := &userAgentTransport{next: baseTransport, userAgent: userAgent}
 	client = &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: transport,
+	}
+	// only API responses are recorded, downloads are left alone
+	var apiTransport http.RoundTripper = transport
+	var crashTransport http.RoundTripper = &userAgentTransport{next: crashBaseTransport, userAgent: userAgent}
+	if opts.record != "" {
+		apiTransport = &recorder{next: apiTransport, dir: opts.record}
+		crashTransport = &recorder{next: crashTransport, dir: opts.record}
+	}
+	githubRequests := &requestCounter{next: apiTransport}
+	crashRequests := &requestCounter{next: crashTransport}
+	githubClient = &http.Client{
+		Timeout:   githubTimeout,
+		Transport: githubRequests,
 	}
+	crashClient = &http.Client{
+		Timeout:   crashTimeout,
+		Transport: crashRequests,
+	}
+	defer func() {
+		logger.Info("run summary",
+			"duration", time.Since(start).Round(time.Millisecond),
+			"github_requests", githubRequests.requests.Load(),
+			"spire_requests", crashRequests.requests.Load(),
+			"rate_limit_remaining", githubRequests.remaining())
+	}()
 
-	// first, get a list of releases
-	releases, err := githubReleases()
+	bodyRule, err := release.ParseBodyRule(opts.bodyRule)
 	if err != nil {
-		return fmt.Errorf("githubReleases: %w", err)
+		return nil, err
+	}
+	policies, err := parsePolicies(opts.policies, minAge, keywords, opts.minFixes, bodyRule)
+	if err != nil {
+		return nil, err
+	}
+	selectOpts := release.Options{
+		MinAge:          minAge,
+		FallbackAge:     fallbackAge,
+		MinGap:          minGap,
+		GapBehavior:     gapBehavior,
+		Keywords:        keywords,
+		MinFixes:        opts.minFixes,
+		MinBodyLength:   opts.minBodyLength,
+		RequireAssets:   opts.requireAssets,
+		MaxCrashServers: opts.maxCrashServers,
+		MinAdoption:     opts.minAdoption,
+		CrashPrefetch:   opts.crashPrefetch,
+		MaxCandidates:   opts.maxCandidates,
+		StableCount:     opts.stableCount,
+		SelectionMode:   selectionMode,
+		TrailCount:      opts.trailCount,
+		Policies:        policies,
+		CrashSoftFail:   opts.crashCheckSoftFail,
+		Since:           since,
+		AllowNonSemver:  opts.allowNonSemver,
+		TagPrefix:       opts.tagPrefix,
+		Logger:          logger,
+	}
+	selectOpts.Weights = release.ScoreWeights{
+		Recency:         opts.scoreRecency,
+		RecencyHalfLife: scoreHalfLife,
+		Crashes:         opts.scoreCrashes,
+		Fixes:           opts.scoreFixes,
+	}
+	crashNow = selectOpts.CurrentTime
+	if !opts.skipCrashCheck {
+		counts := newCrashCounts()
+		selectOpts.CrashCount = func(version string) (int, error) {
+			return counts.get(ctx, version)
+		}
+	}
+	if opts.minAdoption > 0 {
+		adoption := newAdoptionCounts()
+		selectOpts.AdoptionCount = func(version string) (int, error) {
+			return adoption.get(ctx, version)
+		}
 	}
 
-	var latestUnstableRelease *releaseJson
-	var latestStableRelease *releaseJson
-	var fallbackRelease *releaseJson
-	var lastReleasePublishDate time.Time
+	if opts.ping {
+		return nil, runPing(ctx, os.Stdout)
+	}
+	if opts.checkTag != "" {
+		return nil, runCheck(ctx, opts.repo, opts.checkTag, selectOpts, os.Stdout)
+	}
+	if opts.list {
+		return nil, runList(ctx, opts, selectOpts, os.Stdout)
+	}
 
-	for _, release := range releases {
-		if release.Prerelease {
-			fmt.Println("Skipping", release.TagName, "since it's a prerelease")
-			continue
+	result := &runResult{}
+	if opts.reposFile == "" {
+		repoResult, err := runRepo(ctx, opts, opts.repo, opts.outDir, selectOpts, selectedChannels)
+		if repoResult != nil {
+			result.repos = append(result.repos, repoResult)
 		}
-		if latestUnstableRelease == nil {
-			latestUnstableRelease = release
+		return result, err
+	}
+
+	repos, err := readReposFile(opts.reposFile)
+	if err != nil {
+		return nil, err
+	}
+	// a failing repo shouldn't stop the others from being selected
+	errs := []error{}
+	for _, repo := range repos {
+		repoResult, err := runRepo(ctx, opts, repo, filepath.Join(opts.outDir, filepath.FromSlash(repo)), selectOpts, selectedChannels)
+		if repoResult != nil {
+			result.repos = append(result.repos, repoResult)
 		}
-		// convert PublishedAt 2023-09-18T17:19:56Z to time.Time
-		publishedAt, err := time.Parse(time.RFC3339, release.PublishedAt)
 		if err != nil {
-			return fmt.Errorf("parse published at: %w", err)
+			logger.Error("repo failed", "repo", repo, "err", err)
+			errs = append(errs, fmt.Errorf("%s: %w", repo, err))
 		}
+	}
+	logger.Info("repos summary", "repos", len(repos), "succeeded", len(repos)-len(errs), "failed", len(errs))
+	return result, errors.Join(errs...)
+}
 
-		if !lastReleasePublishDate.IsZero() &&
-			lastReleasePublishDate.Add(-3*24*time.Hour).Before(publishedAt) {
-			fmt.Printf("Skipping %s, too close to last release (last: %s this: %s)\n", release.TagName, lastReleasePublishDate, publishedAt)
-			lastReleasePublishDate = publishedAt
-			continue
+// runResult is what run selected, one repoResult per repo that was selected successfully
+type runResult struct {
+	repos []*repoResult
+}
+
+// repoResult is the outcome of selecting releases of one repo, before anything is written
+type repoResult struct {
+	repo   string
+	outDir string
+	// releases are the fetched releases, one per tag
+	releases []*release.Release
+	// selected is the release chosen for each channel, channels without one are missing
+	selected map[string]*release.Release
+	// prerelease is the newest prerelease, set with -emit-prerelease
+	prerelease   *release.Release
+	usedFallback bool
+	// decisions are every release skipped or selected, in the order they were made
+	decisions []channelDecision
+	// previousState is the state left by the last run, nil on the first run
+	previousState *runState
+	// selectedAt is the time release ages were measured from, by the selection clock
+	selectedAt time.Time
+}
+
+// channelDecision is a decision made while selecting a channel
+type channelDecision struct {
+	channel  string
+	decision release.Decision
+}
+
+// stable returns the selected stable release, nil if the stable channel wasn't selected
+func (r *repoResult) stable() *release.Release {
+	return r.selected["stable"]
+}
+
+// reasonCounts counts the stable channel's decisions by reason
+func (r *repoResult) reasonCounts() map[release.Reason]int {
+	counts := map[release.Reason]int{}
+	for _, d := range r.decisions {
+		if d.channel == "stable" {
+			counts[d.decision.Reason]++
 		}
+	}
+	return counts
+}
 
-		if fallbackRelease == nil &&
-			time.Since(publishedAt) > 30*24*time.Hour {
-			fallbackRelease = release
-			fmt.Println("Setting fallback release to", release.TagName, "since it's 30 days old")
+// stableCandidates returns the stable candidates in order of preference, starting with stable.
+// A fallback, kept or pinned stable is the only candidate.
+func (r *repoResult) stableCandidates() []*release.Release {
+	candidates := []*release.Release{}
+	for _, d := range r.decisions {
+		if d.channel == "stable" && (d.decision.Reason == release.ReasonSelected || d.decision.Reason == release.ReasonCandidate) {
+			candidates = append(candidates, d.decision.Release)
 		}
-		fmt.Println("Checking release", release.TagName)
-		lastReleasePublishDate = publishedAt
+	}
+	stable := r.stable()
+	if stable != nil && (len(candidates) == 0 || candidates[0] != stable) {
+		candidates = []*release.Release{stable}
+	}
+	return candidates
+}
 
-		// if stable release is less than a week old, skip it
-		if time.Since(publishedAt) < 7*24*time.Hour {
-			fmt.Printf("Skipping %s, too new\n", release.TagName)
+// summary describes the stable channel's decisions on one line, e.g.
+// "considered 42, skipped: no-fix=8 too-new=3, selected v1.2.3"
+func (r *repoResult) summary() string {
+	counts := r.reasonCounts()
+	skipped := []string{}
+	for reason, count := range counts {
+		if reason == release.ReasonSelected || reason == release.ReasonCandidate || reason == release.ReasonFallback {
 			continue
 		}
-		//fallback release is 30 days old release
+		skipped = append(skipped, fmt.Sprintf("%s=%d", strings.ReplaceAll(strings.ToLower(string(reason)), "_", "-"), count))
+	}
+	sort.Strings(skipped)
+	if len(skipped) == 0 {
+		skipped = append(skipped, "none")
+	}
+	selected := "nothing"
+	if stable := r.stable(); stable != nil {
+		selected = stable.TagName
+		if r.usedFallback {
+			selected += " (fallback)"
+		}
+	}
+	return fmt.Sprintf("considered %d, skipped: %s, selected %s", len(r.releases), strings.Join(skipped, " "), selected)
+}
 
-		if !strings.Contains(release.Body, "Fix") {
-			fmt.Printf("Skipping %s, no fixes\n", release.TagName)
-			continue
+// errorCounts returns the crash count observed for each tag that was crash checked
+func (r *repoResult) errorCounts() map[string]int {
+	counts := map[string]int{}
+	for _, d := range r.decisions {
+		if d.decision.ErrorCount != nil {
+			counts[d.decision.Release.TagName] = *d.decision.ErrorCount
 		}
+	}
+	return counts
+}
 
-		releaseTag := strings.ReplaceAll(release.TagName, "v", "")
-		errorCount, err := errorCount(releaseTag)
+// runRepo selects releases of repo and writes them to outDir
+func runRepo(ctx context.Context, opts *options, repo string, outDir string, selectOpts release.Options, selectedChannels []channel) (*repoResult, error) {
+	if !opts.dryRun && !opts.diff && opts.output == "files" {
+		err := checkWritable(outDir)
 		if err != nil {
-			return fmt.Errorf("errorCount: %w", err)
+			return nil, &release.StageError{Stage: release.ErrWriteOutput, Err: err}
 		}
+	}
+	result, err := selectRepo(ctx, opts, repo, outDir, selectOpts, selectedChannels)
+	if err != nil {
+		return nil, err
+	}
+	err = writeRepo(ctx, opts, result, selectedChannels)
+	logger.Info("selection summary", "repo", repo, "summary", result.summary())
+	return result, err
+}
 
-		if errorCount > 0 {
-			fmt.Printf("%s has %d errors, skipping\n", releaseTag, errorCount)
-			continue
+// selectRepo fetches the releases of repo and selects one for each channel without writing anything
+func selectRepo(ctx context.Context, opts *options, repo string, outDir string, selectOpts release.Options, selectedChannels []channel) (*repoResult, error) {
+	var err error
+	result := &repoResult{repo: repo, outDir: outDir, selected: map[string]*release.Release{}, selectedAt: selectOpts.CurrentTime()}
+
+	// first, get a list of releases
+	cache := &releasesCache{
+		path:     filepath.Join(outDir, "releases.cache.json"),
+		fresh:    opts.noCache,
+		readOnly: opts.dryRun || opts.diff || opts.output == "stdout",
+		stale:    !opts.noStale,
+	}
+	var releases []*release.Release
+	if opts.releasesFile != "" {
+		releases, err = releasesFromFile(opts.releasesFile)
+		if err != nil {
+			return nil, err
 		}
+	} else {
+		releases, err = githubReleases(ctx, repo, cache)
+		if err != nil {
+			return nil, &release.StageError{Stage: release.ErrGitHubFetch, Err: fmt.Errorf("githubReleases: %w", err)}
+		}
+	}
+	releases = uniqueTags(releases)
+	result.releases = releases
 
-		latestStableRelease = release
-		break
+	if opts.promote {
+		selectOpts.PromoteFrom, err = currentStable(filepath.Join(outDir, opts.stableFile))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if latestStableRelease == nil {
-		if fallbackRelease == nil {
-			return fmt.Errorf("no releases found")
+	var pinned *release.Release
+	if opts.pinStable != "" {
+		pinned, err = pinnedRelease(ctx, repo, opts.pinStable, releases, opts.releasesFile != "")
+		if err != nil {
+			return nil, err
 		}
-		fmt.Println("No releases found, using fallback release")
-		latestStableRelease = fallbackRelease
+		logger.Warn("STABLE IS PINNED, not running the stable gates", "tag", pinned.TagName)
 	}
 
-	err = os.MkdirAll("bin", 0755)
-	if err != nil {
-		return fmt.Errorf("mkdir: %w", err)
+	for _, c := range selectedChannels {
+		if c.name == "stable" && pinned != nil {
+			result.selected[c.name] = pinned
+			continue
+		}
+		rules := opts.channelRules.apply(c.name, c.rules(selectOpts), selectOpts)
+		if c.name == "unstable" && opts.allowPrereleaseUnstable {
+			rules.Prereleases = release.PrereleasesInclude
+		}
+		if c.name == "unstable" && opts.unstableSource == "prerelease" {
+			rules.Prereleases = release.PrereleasesOnly
+		}
+		name := c.name
+		rules.OnDecision = func(decision release.Decision) {
+			result.decisions = append(result.decisions, channelDecision{channel: name, decision: decision})
+		}
+		recorded := len(result.decisions)
+		rel, _, fallback, err := release.SelectReleases(releases, rules)
+		if errors.Is(err, release.ErrNoRelease) && rules.Prereleases == release.PrereleasesOnly && c.name == "unstable" {
+			logger.Warn("no prerelease to use as unstable, using the highest release")
+			result.decisions = result.decisions[:recorded]
+			rules.Prereleases = release.PrereleasesSkip
+			rel, _, fallback, err = release.SelectReleases(releases, rules)
+		}
+		if errors.Is(err, release.ErrNoRelease) && c.optional {
+			logger.Warn("no release qualified, skipping channel", "channel", c.name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("select %s release: %w", c.name, err)
+		}
+		if c.name == "stable" && fallback {
+			result.usedFallback = true
+			logger.Warn("no release qualified as stable, using fallback release", "tag", rel.TagName, "fallback_age", selectOpts.FallbackAge)
+		}
+		result.selected[c.name] = rel
+		logger.Info("selected release", "channel", c.name, "tag", rel.TagName)
 	}
-	fmt.Println("Latest unstable release:", latestUnstableRelease.TagName)
-	fmt.Println("Latest stable release:", latestStableRelease.TagName)
-	err = os.WriteFile("bin/latest.txt", []byte(latestUnstableRelease.TagName), 0644)
-	if err != nil {
-		return fmt.Errorf("write latest.txt: %w", err)
+	// a snapshot is selected offline, and GitHub's latest is never a prerelease
+	unstable := result.selected["unstable"]
+	if unstable != nil && !unstable.Prerelease && opts.releasesFile == "" {
+		result.selected["unstable"] = checkGithubLatest(ctx, repo, unstable, releases, opts.tagPrefix, opts.preferGithubLatest)
+	}
+	if opts.emitPrerelease {
+		prefixed := []*release.Release{}
+		for _, rel := range releases {
+			if strings.HasPrefix(rel.TagName, opts.tagPrefix) {
+				prefixed = append(prefixed, rel)
+			}
+		}
+		result.prerelease = release.NewestPrerelease(prefixed)
+		if result.prerelease == nil {
+			logger.Warn("no prerelease found, not writing prerelease.txt")
+		} else {
+			logger.Info("newest prerelease", "tag", result.prerelease.TagName)
+		}
 	}
 
-	err = os.WriteFile("bin/stable.txt", []byte(latestStableRelease.TagName), 0644)
+	result.previousState, err = loadState(filepath.Join(outDir, stateFile))
 	if err != nil {
-		return fmt.Errorf("write stable.txt: %w", err)
+		return nil, err
 	}
-
-	return nil
+	return result, nil
 }
 
-func githubReleases() ([]*releaseJson, error) {
-	resp, err := client.Get("https://api.github.com/repos/eqemu/server/releases")
+// writeRepo writes the audit log, output files and downloads for result, or prints what
+// would change with -diff and -dry-run, then pushes metrics and notifies of a new stable
+func writeRepo(ctx context.Context, opts *options, result *repoResult, selectedChannels []channel) error {
+	audit, err := openAuditLog(opts.auditFile)
 	if err != nil {
-		return nil, fmt.Errorf("get releases: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	defer audit.Close()
+	for _, d := range result.decisions {
+		audit.recordDecision(d.channel, d.decision)
+	}
+	err = audit.Err()
+	if err != nil {
+		return err
+	}
+
+	outDir := result.outDir
+	selected := result.selected
+	latestStableRelease := result.stable()
+	newestPrerelease := result.prerelease
+	decisions := result.reasonCounts()
+	errorCounts := result.errorCounts()
+	previousState := result.previousState
+	regressionErr := checkRegression(previousState, latestStableRelease, opts.tagPrefix, opts.failOnRegression)
 
-	// read resp body to buf
-	payloads := []*releaseJson{}
-	err = json.NewDecoder(resp.Body).Decode(&payloads)
+	candidates := result.stableCandidates()
+	outputs := []outputFile{}
+	if opts.format == "json" {
+		selection := &selectionJson{
+			Stable:       newSelectedReleaseJson(selected["stable"], errorCounts),
+			Unstable:     newSelectedReleaseJson(selected["unstable"], errorCounts),
+			Bleeding:     newSelectedReleaseJson(selected["bleeding"], errorCounts),
+			Prerelease:   newSelectedReleaseJson(newestPrerelease, errorCounts),
+			UsedFallback: result.usedFallback,
+			Decisions:    decisions,
+			Summary:      result.summary(),
+			Version:      currentBuild().Version,
+		}
+		// the list is only written when several candidates were asked for
+		if opts.stableCount > 1 {
+			selection.StableCandidates = []*selectedReleaseJson{}
+			for _, rel := range candidates {
+				selection.StableCandidates = append(selection.StableCandidates, newSelectedReleaseJson(rel, errorCounts))
+			}
+		}
+		data, err := marshalOutput(selection, opts.jsonPretty)
+		if err != nil {
+			return fmt.Errorf("marshal selection: %w", err)
+		}
+		outputs = append(outputs, outputFile{path: filepath.Join(outDir, "selection.json"), data: data})
+	} else if opts.format == "txt" || opts.envFiles {
+		files := map[string]string{
+			"stable":   opts.stableFile,
+			"unstable": opts.latestFile,
+			"bleeding": opts.bleedingFile,
+		}
+		for _, c := range selectedChannels {
+			rel, ok := selected[c.name]
+			if !ok {
+				continue
+			}
+			outputs = append(outputs, outputFile{path: filepath.Join(outDir, files[c.name]), data: []byte(outputValue(rel, opts.selectBy))})
+		}
+		if newestPrerelease != nil {
+			outputs = append(outputs, outputFile{path: filepath.Join(outDir, "prerelease.txt"), data: []byte(outputValue(newestPrerelease, opts.selectBy))})
+		}
+		if opts.stableCount > 1 && latestStableRelease != nil {
+			lines := []string{}
+			for _, rel := range candidates {
+				lines = append(lines, outputValue(rel, opts.selectBy))
+			}
+			outputs = append(outputs, outputFile{path: filepath.Join(outDir, "stable-candidates.txt"), data: []byte(strings.Join(lines, "\n") + "\n")})
+		}
+	}
+	// the outputs so far are the results printed with -output stdout
+	results := outputs
+	if opts.stableJSON {
+		data, err := marshalOutput(latestStableRelease, opts.jsonPretty)
+		if err != nil {
+			return fmt.Errorf("marshal stable release: %w", err)
+		}
+		outputs = append(outputs, outputFile{path: filepath.Join(outDir, "stable.json"), data: data})
+	}
+	state := &runState{Version: currentBuild().Version}
+	if selected["stable"] != nil {
+		state.Stable = selected["stable"].TagName
+	}
+	if selected["unstable"] != nil {
+		state.Unstable = selected["unstable"].TagName
+	}
+	data, err := marshalOutput(state, opts.jsonPretty)
 	if err != nil {
-		return nil, fmt.Errorf("decode releases: %w", err)
+		return fmt.Errorf("marshal state: %w", err)
+	}
+	// the state records the tool version, so an upgrade alone mustn't show up as a change
+	diffed := outputs
+	outputs = append(outputs, outputFile{path: filepath.Join(outDir, stateFile), data: data})
+
+	envSelected := map[string]*release.Release{"prerelease": newestPrerelease}
+	for name, rel := range selected {
+		envSelected[name] = rel
+	}
+
+	assets := []release.Asset{}
+	if opts.download {
+		assets, err = matchingAssets(latestStableRelease, opts.assetPattern)
+		if err != nil {
+			return err
+		}
+		if len(assets) == 0 {
+			logger.Warn("no assets to download", "tag", latestStableRelease.TagName)
+		}
+	}
+	var source sourceArchive
+	if opts.downloadSource != "" {
+		source, err = newSourceArchive(latestStableRelease, opts.downloadSource)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.diff {
+		return diffOutputs(os.Stdout, diffed)
+	}
+	if opts.dryRun {
+		for _, output := range outputs {
+			logger.Info("dry run, would write file", "path", output.path, "data", string(output.data))
+		}
+		for _, asset := range assets {
+			logger.Info("dry run, would download asset", "url", asset.BrowserDownloadURL, "dir", outDir)
+		}
+		if source.url != "" {
+			logger.Info("dry run, would download source archive", "url", source.url, "path", filepath.Join(outDir, source.name))
+		}
+		if opts.metricsPush != "" {
+			logger.Info("dry run, would push metrics", "url", opts.metricsPush)
+		}
+		if opts.format == "env" {
+			os.Stdout.Write(envOutput(envSelected))
+		}
+		return errors.Join(regressionErr, fallbackErr(opts, result.usedFallback, latestStableRelease))
+	}
+
+	if opts.output == "stdout" {
+		err = printOutputs(os.Stdout, results)
+		if err != nil {
+			return err
+		}
+	} else {
+		if opts.download {
+			err = downloadAssets(ctx, outDir, assets)
+			if err != nil {
+				return err
+			}
+			err = verifyChecksums(ctx, latestStableRelease, outDir, assets, opts.requireChecksums)
+			if err != nil {
+				return err
+			}
+		}
+		if source.url != "" {
+			err = downloadSource(ctx, outDir, source)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeOutputs(outDir, outputs, opts.force)
+		if err != nil {
+			return &release.StageError{Stage: release.ErrWriteOutput, Err: err}
+		}
+	}
+	if opts.format == "env" {
+		_, err = os.Stdout.Write(envOutput(envSelected))
+		if err != nil {
+			return fmt.Errorf("write env: %w", err)
+		}
 	}
 
-	releases := append([]*releaseJson{}, payloads...)
+	if opts.metricsPush != "" {
+		metrics := &runMetrics{
+			considered: len(result.releases),
+			decisions:  decisions,
+		}
+		publishedAt, err := release.ParseTimestamp(latestStableRelease.PublishedAt)
+		if err == nil {
+			metrics.stableAge = result.selectedAt.Sub(publishedAt)
+		}
+		count, ok := errorCounts[latestStableRelease.TagName]
+		if ok {
+			metrics.stableErrorCount = &count
+		}
+		// failing to push shouldn't fail a run whose files were already written
+		err = pushMetrics(ctx, opts.metricsPush, metrics)
+		if err != nil {
+			logger.Warn("failed to push metrics", "url", opts.metricsPush, "err", err)
+		}
+	}
+	// the first run has nothing to compare against, so it isn't a promotion
+	if opts.notifyWebhook != "" && previousState != nil && previousState.Stable != "" && previousState.Stable != latestStableRelease.TagName {
+		// webhook urls embed their secret, so the url isn't logged
+		err = notifyPromotion(ctx, opts.notifyWebhook, result.repo, previousState.Stable, latestStableRelease)
+		if err != nil {
+			logger.Warn("failed to notify stable promotion", "err", err)
+		} else {
+			logger.Info("notified stable promotion", "old_stable", previousState.Stable, "new_stable", latestStableRelease.TagName)
+		}
+	}
+	return errors.Join(regressionErr, fallbackErr(opts, result.usedFallback, latestStableRelease))
+}
 
-	return releases, nil
+// fallbackErr returns errUsedFallback if the fallback release was used and failOnFallback is set
+func fallbackErr(opts *options, usedFallback bool, stable *release.Release) error {
+	if !usedFallback || !opts.failOnFallback {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errUsedFallback, stable.TagName)
 }
 
-func errorCount(tag string) (int, error) {
-	resp, err := client.Get(fmt.Sprintf("http://spire.akkadius.com/api/v1/analytics/server-crash-reports?version=%s", tag))
+// checkGithubLatest warns when the release GitHub designates as latest in repo isn't unstable,
+// which happens when a maintainer marks an older release latest by hand. With prefer it returns
+// GitHub's choice instead, otherwise unstable, which is also returned if the check can't be made.
+func checkGithubLatest(ctx context.Context, repo string, unstable *release.Release, releases []*release.Release, tagPrefix string, prefer bool) *release.Release {
+	latest, err := githubLatestRelease(ctx, repo)
 	if err != nil {
-		return 0, fmt.Errorf("get error count: %w", err)
+		logger.Warn("failed to fetch github's latest release, not cross-checking unstable", "err", err)
+		return unstable
 	}
-	defer resp.Body.Close()
+	if latest == nil || latest.TagName == unstable.TagName {
+		return unstable
+	}
+	if !strings.HasPrefix(latest.TagName, tagPrefix) {
+		logger.Debug("github's latest release doesn't have the tag prefix, not cross-checking unstable", "github_latest", latest.TagName, "tag_prefix", tagPrefix)
+		return unstable
+	}
+	if !prefer {
+		logger.Warn("github's latest release isn't the selected unstable release, it may have been marked latest by hand",
+			"unstable", unstable.TagName, "github_latest", latest.TagName)
+		return unstable
+	}
+	logger.Warn("using github's latest release as unstable instead of the highest version",
+		"unstable", unstable.TagName, "github_latest", latest.TagName)
+	for _, rel := range releases {
+		if rel.TagName == latest.TagName {
+			return rel
+		}
+	}
+	return latest
+}
 
-	type errorCountJson struct {
-		Id              int    `json:"id"`
-		ServerName      string `json:"server_name"`
-		ServerShortName string `json:"server_short_name"`
-		ServerVersion   string `json:"server_version"`
+// pinnedRelease returns the release tagged tag, looking it up with the GitHub API unless the
+// releases came from a file, in which case it must be one of them
+func pinnedRelease(ctx context.Context, repo string, tag string, releases []*release.Release, fromFile bool) (*release.Release, error) {
+	if !fromFile {
+		rel, err := githubRelease(ctx, repo, tag)
+		if err != nil {
+			return nil, fmt.Errorf("pin-stable: %w", err)
+		}
+		return rel, nil
+	}
+	for _, rel := range releases {
+		if rel.TagName == tag {
+			return rel, nil
+		}
 	}
+	return nil, fmt.Errorf("pin-stable: no release tagged %s in the releases file", tag)
+}
 
-	// read resp body to buf
-	payloads := []*errorCountJson{}
-	err = json.NewDecoder(resp.Body).Decode(&payloads)
+// currentStable returns the tag in the stable file at path, or empty if it doesn't exist yet
+func currentStable(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("no current stable release, selecting from every release", "path", path)
+		return "", nil
+	}
 	if err != nil {
-		return 0, fmt.Errorf("decode error count: %w", err)
+		return "", fmt.Errorf("read current stable: %w", err)
 	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	servers := make(map[string]string)
-	count := 0
-	for _, payload := range payloads {
-		if _, ok := servers[payload.ServerName]; ok {
+// validateRepo checks repo looks like owner/name
+func validateRepo(repo string) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid repo %q, expected owner/name", repo)
+	}
+	return nil
+}
+
+// readReposFile reads owner/name repositories, one per line. Blank lines and lines
+// starting with # are ignored.
+func readReposFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read repos file: %w", err)
+	}
+	repos := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		servers[payload.ServerName] = payload.ServerName
-		count++
+		err = validateRepo(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		repos = append(repos, line)
 	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("%s: no repos listed", path)
+	}
+	return repos, nil
+}
 
-	return count, nil
-
+// parseDuration parses a duration flag value, naming the flag on failure
+func parseDuration(name string, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("parse %s: %s is negative", name, value)
+	}
+	return d, nil
 }
@@ -0,0 +1,1926 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// testOptions returns options matching the command line defaults
+func testOptions() *options {
+	return &options{
+		minAge:         "168h",
+		fallbackAge:    "720h",
+		minGap:         "72h",
+		gapBehavior:    "skip",
+		selectionMode:  "first",
+		scoreHalfLife:  "336h",
+		output:         "files",
+		unstableSource: "release",
+		selectBy:       "tag",
+		stableCount:    1,
+		retries:        1,
+		retryDelay:     "0s",
+		maxRetryAfter:  "60s",
+		timeout:        "0s",
+		startupJitter:  "0s",
+		githubTimeout:  "10s",
+		crashTimeout:   "10s",
+		format:         "txt",
+		repo:           "eqemu/server",
+		outDir:         "bin",
+		latestFile:     "latest.txt",
+		stableFile:     "stable.txt",
+		bleedingFile:   "bleeding.txt",
+		channels:       "stable,unstable",
+		policies:       "age,keywords",
+		bodyRule:       `"Fix"`,
+		minFixes:       1,
+		crashDedupeKey: "name",
+		crashWindow:    "0s",
+		crashPrefetch:  4,
+		logFormat:      "text",
+	}
+}
+
+// testRelease builds a release published age ago
+func testRelease(tag string, age time.Duration, body string) *release.Release {
+	return &release.Release{
+		Name:        tag,
+		TagName:     tag,
+		PublishedAt: time.Now().Add(-age).UTC().Format(time.RFC3339),
+		Body:        body,
+	}
+}
+
+// testCrash is a crash report payload served by newTestServer
+type testCrash struct {
+	ServerName      string `json:"server_name"`
+	ServerShortName string `json:"server_short_name"`
+	// ServerVersion defaults to the requested version
+	ServerVersion string `json:"server_version"`
+	CreatedAt     string `json:"created_at,omitempty"`
+}
+
+// newTestServer serves releases for eqemu/server and crashes keyed by version,
+// pointing githubAPIBase and crashReportURL at itself for the duration of the test
+func newTestServer(t *testing.T, releases []*release.Release, crashes map[string][]testCrash) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/eqemu/server/releases", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(releases)
+	})
+	mux.HandleFunc("/repos/eqemu/server/releases/tags/", func(w http.ResponseWriter, r *http.Request) {
+		tag := strings.TrimPrefix(r.URL.Path, "/repos/eqemu/server/releases/tags/")
+		for _, rel := range releases {
+			if rel.TagName == tag {
+				json.NewEncoder(w).Encode(rel)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/crashes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		version := r.URL.Query().Get("version")
+		payloads := []testCrash{}
+		for _, payload := range crashes[version] {
+			if payload.ServerVersion == "" {
+				payload.ServerVersion = version
+			}
+			payloads = append(payloads, payload)
+		}
+		json.NewEncoder(w).Encode(payloads)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	oldGithub, oldCrash := githubAPIBase, crashReportURL
+	githubAPIBase = server.URL
+	crashReportURL = server.URL + "/crashes"
+	t.Cleanup(func() {
+		githubAPIBase, crashReportURL = oldGithub, oldCrash
+	})
+}
+
+// chdirTemp changes into a temporary directory for the duration of the test
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
+
+// readOutput returns the trimmed contents of a file written by run
+func readOutput(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestRun(t *testing.T) {
+	day := 24 * time.Hour
+	prerelease := testRelease("v2.1.0", 1*day, "Fix crash")
+	prerelease.Prerelease = true
+
+	tests := []struct {
+		name     string
+		releases []*release.Release
+		crashes  map[string][]testCrash
+		// configure adjusts the default options
+		configure  func(opts *options)
+		wantLatest string
+		wantStable string
+		wantErr    string
+	}{
+		{
+			name: "prerelease skipped",
+			releases: []*release.Release{
+				prerelease,
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "too new skipped",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 1*day, "Fix zone crash"),
+				testRelease("v1.9.0", 10*day, "Fix login"),
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v1.9.0",
+		},
+		{
+			name: "fallback selected",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "New zone"),
+				testRelease("v1.9.0", 40*day, "New spells"),
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v1.9.0",
+		},
+		{
+			name: "crashing release rejected",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			crashes: map[string][]testCrash{
+				"2.0.0": {{ServerName: "a"}, {ServerName: "b"}, {ServerName: "a"}},
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v1.9.0",
+		},
+		{
+			name: "stale crashes outside window",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			crashes: map[string][]testCrash{
+				"2.0.0": {
+					{ServerName: "a", CreatedAt: time.Now().Add(-90 * day).Format(time.RFC3339)},
+					{ServerName: "b", CreatedAt: time.Now().Add(-90 * day).Format(time.RFC3339)},
+				},
+			},
+			configure: func(opts *options) {
+				opts.crashWindow = "720h"
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "crashes deduped by short name",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			crashes: map[string][]testCrash{
+				"2.0.0": {{ServerName: "Zone A", ServerShortName: "a"}, {ServerName: "Zone A (test)", ServerShortName: "a"}},
+			},
+			configure: func(opts *options) {
+				opts.crashDedupeKey = "shortname"
+				opts.maxCrashServers = 1
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "crashes for other versions ignored",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			crashes: map[string][]testCrash{
+				"2.0.0": {{ServerName: "a", ServerVersion: "2.0"}, {ServerName: "b", ServerVersion: "2.0.0-dev"}},
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "fallback bounded by since",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "New zone"),
+				testRelease("v1.9.0", 40*day, "New spells"),
+			},
+			configure: func(opts *options) {
+				opts.since = time.Now().Add(-20 * day).Format(time.DateOnly)
+			},
+			wantErr: "no releases found",
+		},
+		{
+			name: "prerelease allowed as latest",
+			releases: []*release.Release{
+				prerelease,
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.allowPrereleaseUnstable = true
+			},
+			wantLatest: "v2.1.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "prerelease as unstable source",
+			releases: []*release.Release{
+				testRelease("v2.2.0", 1*day, "Fix login"),
+				prerelease,
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.unstableSource = "prerelease"
+			},
+			wantLatest: "v2.1.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "unstable source falls back without prereleases",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.unstableSource = "prerelease"
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "select by name",
+			releases: []*release.Release{
+				{Name: "PEQ 2024-07", TagName: "v2.1.0", PublishedAt: time.Now().Add(-1 * day).UTC().Format(time.RFC3339), Body: "Fix login"},
+				{Name: " ", TagName: "v2.0.0", PublishedAt: time.Now().Add(-10 * day).UTC().Format(time.RFC3339), Body: "Fix zone crash"},
+			},
+			configure: func(opts *options) {
+				opts.selectBy = "name"
+			},
+			wantLatest: "PEQ 2024-07",
+			// the stable release has no name, so its tag is written
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "invalid select by",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.selectBy = "id"
+			},
+			wantErr: "unknown select-by",
+		},
+		{
+			name: "invalid crash api base",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.crashAPIBase = "spire.example.com/crashes"
+			},
+			wantErr: "invalid crash-api-base",
+		},
+		{
+			name: "keyword policy dropped",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "New zone"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			configure: func(opts *options) {
+				opts.policies = "age"
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v2.0.0",
+		},
+		{
+			name: "body rule rejects breaking release",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash\nBREAKING: new schema"),
+				testRelease("v1.9.0", 20*day, "Fix login"),
+			},
+			configure: func(opts *options) {
+				opts.policies = "age,body"
+				opts.bodyRule = `"Fix" AND NOT "BREAKING"`
+			},
+			wantLatest: "v2.0.0",
+			wantStable: "v1.9.0",
+		},
+		{
+			name: "malformed body rule",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "Fix zone crash"),
+			},
+			configure: func(opts *options) {
+				opts.bodyRule = `"Fix" AND`
+			},
+			wantErr: "unexpected end of rule",
+		},
+		{
+			name: "nothing qualifies",
+			releases: []*release.Release{
+				testRelease("v2.0.0", 10*day, "New zone"),
+			},
+			wantErr: "no releases found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestServer(t, tt.releases, tt.crashes)
+			chdirTemp(t)
+
+			opts := testOptions()
+			if tt.configure != nil {
+				tt.configure(opts)
+			}
+			_, err := run(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("run() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			latest := readOutput(t, "bin/latest.txt")
+			if latest != tt.wantLatest {
+				t.Errorf("latest.txt = %q, want %q", latest, tt.wantLatest)
+			}
+			stable := readOutput(t, "bin/stable.txt")
+			if stable != tt.wantStable {
+				t.Errorf("stable.txt = %q, want %q", stable, tt.wantStable)
+			}
+		})
+	}
+}
+
+func TestRunStableJSON(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.stableJSON = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	stable := &release.Release{}
+	err = json.Unmarshal([]byte(readOutput(t, "bin/stable.json")), stable)
+	if err != nil {
+		t.Fatalf("decode stable.json: %v", err)
+	}
+	if stable.TagName != "v2.0.0" || stable.Body != "Fix zone crash" {
+		t.Errorf("stable.json = %+v, want v2.0.0 with its body", stable)
+	}
+	if readOutput(t, "bin/stable.txt") != "v2.0.0" {
+		t.Errorf("stable.txt not written alongside stable.json")
+	}
+	entries, err := os.ReadDir("bin")
+	if err != nil {
+		t.Fatalf("read bin: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestRunDownloadSource(t *testing.T) {
+	day := 24 * time.Hour
+	archives := http.NewServeMux()
+	archives.HandleFunc("/tarball/v2.0.0", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/codeload/v2.0.0.tar.gz", http.StatusFound)
+	})
+	archives.HandleFunc("/codeload/v2.0.0.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("source"))
+	})
+	archiveServer := httptest.NewServer(archives)
+	t.Cleanup(archiveServer.Close)
+
+	rel := testRelease("v2.0.0", 10*day, "Fix zone crash")
+	rel.TarballURL = archiveServer.URL + "/tarball/v2.0.0"
+	newTestServer(t, []*release.Release{rel}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.downloadSource = "tar"
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if readOutput(t, "bin/v2.0.0.tar.gz") != "source" {
+		t.Errorf("v2.0.0.tar.gz does not contain the redirected archive")
+	}
+
+	opts.downloadSource = "zip"
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "no zip source archive") {
+		t.Fatalf("run() error = %v, want missing zip archive", err)
+	}
+}
+
+func TestRunJSONPretty(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.1.0", 1*day, "Fix spells"),
+		testRelease("v2.0.0", 10*day, "New zone"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.format = "json"
+	opts.jsonPretty = true
+	outputs := []string{}
+	for i := 0; i < 2; i++ {
+		_, err := run(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		data, err := os.ReadFile("bin/selection.json")
+		if err != nil {
+			t.Fatalf("read selection.json: %v", err)
+		}
+		outputs = append(outputs, string(data))
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("selection.json changed between identical runs:\n%s\n%s", outputs[0], outputs[1])
+	}
+	want := `  "decisions": {
+    "NO_FIX": 1,
+    "SELECTED": 1,
+    "TOO_NEW": 1
+  },
+  "summary": "considered 3, skipped: no-fix=1 too-new=1, selected v1.9.0",
+  "version": "` + currentBuild().Version + `"
+}
+`
+	if !strings.HasPrefix(outputs[0], "{\n  \"stable\": {") || !strings.HasSuffix(outputs[0], want) {
+		t.Errorf("selection.json =\n%s\nwant it indented with sorted decisions", outputs[0])
+	}
+}
+
+func TestRunEmitPrerelease(t *testing.T) {
+	day := 24 * time.Hour
+	older := testRelease("v2.2.0-rc1", 3*day, "New zone")
+	older.Prerelease = true
+	newer := testRelease("v2.1.1-rc1", 1*day, "New zone")
+	newer.Prerelease = true
+	newTestServer(t, []*release.Release{
+		older,
+		newer,
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, map[string][]testCrash{
+		"2.1.1-rc1": {{ServerName: "a"}, {ServerName: "b"}},
+	})
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.emitPrerelease = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	prerelease := readOutput(t, "bin/prerelease.txt")
+	if prerelease != "v2.1.1-rc1" {
+		t.Errorf("prerelease.txt = %q, want %q", prerelease, "v2.1.1-rc1")
+	}
+}
+
+func TestRunUnwritableOutDir(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("permissions aren't enforced for root")
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+	err := os.Mkdir("bin", 0555)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	_, err = run(context.Background(), opts)
+	if !errors.Is(err, release.ErrWriteOutput) || !strings.Contains(err.Error(), "isn't writable") {
+		t.Fatalf("run() error = %v, want an unwritable out dir error", err)
+	}
+	if requests != 0 {
+		t.Errorf("made %d requests before failing, want 0", requests)
+	}
+}
+
+func TestRunUnchanged(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, nil)
+	chdirTemp(t)
+
+	_, err := run(context.Background(), testOptions())
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes("bin/stable.txt", old, old)
+	if err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	for _, force := range []bool{false, true} {
+		opts := testOptions()
+		opts.force = force
+		_, err = run(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		info, err := os.Stat("bin/stable.txt")
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		rewritten := info.ModTime().After(old.Add(time.Minute))
+		if rewritten != force {
+			t.Errorf("force %t: stable.txt rewritten = %t, want %t", force, rewritten, force)
+		}
+	}
+}
+
+func TestRunFailOnFallback(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "New zone"),
+		testRelease("v1.9.0", 40*day, "New spells"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.failOnFallback = true
+	_, err := run(context.Background(), opts)
+	if !errors.Is(err, errUsedFallback) || !strings.Contains(err.Error(), "v1.9.0") {
+		t.Fatalf("run() error = %v, want %v with the fallback tag", err, errUsedFallback)
+	}
+	if readOutput(t, "bin/stable.txt") != "v1.9.0" {
+		t.Errorf("stable.txt not written when failing on fallback")
+	}
+}
+
+func TestRunPromote(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+		testRelease("v1.8.0", 30*day, "Fix spells"),
+	}, nil)
+	chdirTemp(t)
+	err := os.Mkdir("bin", 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	err = os.WriteFile("bin/stable.txt", []byte("v1.8.0\n"), 0644)
+	if err != nil {
+		t.Fatalf("write stable.txt: %v", err)
+	}
+
+	opts := testOptions()
+	opts.promote = true
+	for _, want := range []string{"v1.9.0", "v2.0.0", "v2.0.0"} {
+		_, err = run(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		stable := readOutput(t, "bin/stable.txt")
+		if stable != want {
+			t.Errorf("stable.txt = %s, want %s", stable, want)
+		}
+	}
+}
+
+func TestRunPinStable(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "New spells"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.pinStable = "v1.9.0"
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if stable := readOutput(t, "bin/stable.txt"); stable != "v1.9.0" {
+		t.Errorf("stable.txt = %s, want the pinned v1.9.0", stable)
+	}
+	if latest := readOutput(t, "bin/latest.txt"); latest != "v2.0.0" {
+		t.Errorf("latest.txt = %s, want v2.0.0", latest)
+	}
+
+	opts.pinStable = "v1.0.0"
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "no release tagged v1.0.0") {
+		t.Fatalf("run() error = %v, want a missing pinned tag error", err)
+	}
+}
+
+func TestRunFailOnRegression(t *testing.T) {
+	day := 24 * time.Hour
+	crashes := map[string][]testCrash{}
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, crashes)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.failOnRegression = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if state := readOutput(t, "bin/state.json"); state != `{"stable":"v2.0.0","unstable":"v2.0.0","version":"`+currentBuild().Version+`"}` {
+		t.Errorf("state.json = %s", state)
+	}
+
+	crashes["2.0.0"] = []testCrash{{ServerName: "a"}, {ServerName: "b"}}
+	_, err = run(context.Background(), opts)
+	if !errors.Is(err, errRegression) {
+		t.Fatalf("run() error = %v, want %v", err, errRegression)
+	}
+	if readOutput(t, "bin/stable.txt") != "v1.9.0" {
+		t.Errorf("stable.txt not written on regression")
+	}
+
+	// the regressed tag is recorded, so the next run doesn't report it again
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+}
+
+func TestRunNotifyWebhook(t *testing.T) {
+	day := 24 * time.Hour
+	payloads := []*promotionJson{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := &promotionJson{}
+		json.NewDecoder(r.Body).Decode(payload)
+		payloads = append(payloads, payload)
+	}))
+	defer webhook.Close()
+	crashes := map[string][]testCrash{
+		"2.0.0": {{ServerName: "a"}, {ServerName: "b"}},
+	}
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, crashes)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.notifyWebhook = webhook.URL
+	run(context.Background(), opts)
+	delete(crashes, "2.0.0")
+	opts.dryRun = true
+	run(context.Background(), opts)
+	if len(payloads) != 0 {
+		t.Fatalf("notified %d times on the first and dry runs, want 0", len(payloads))
+	}
+
+	opts.dryRun = false
+	for i := 0; i < 2; i++ {
+		_, err := run(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("notified %d times, want once for the change", len(payloads))
+	}
+	if payloads[0].OldStable != "v1.9.0" || payloads[0].NewStable != "v2.0.0" || payloads[0].Changelog != "Fix zone crash" {
+		t.Errorf("payload = %+v, want v1.9.0 to v2.0.0 with the changelog", payloads[0])
+	}
+}
+
+func TestRunGithubErrorDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>upstream unavailable</html>"))
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	_, err := run(context.Background(), opts)
+	if !errors.Is(err, release.ErrGitHubFetch) {
+		t.Fatalf("run() error = %v, want %v", err, release.ErrGitHubFetch)
+	}
+	for _, want := range []string{server.URL + "/repos/eqemu/server/releases?per_page=100", "502", "upstream unavailable"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("run() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestRunCrashFetchError(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, nil)
+	chdirTemp(t)
+	crashReportURL = "http://127.0.0.1:1/crashes"
+
+	_, err := run(context.Background(), testOptions())
+	if !errors.Is(err, release.ErrCrashFetch) || errors.Is(err, release.ErrGitHubFetch) {
+		t.Fatalf("run() error = %v, want only %v", err, release.ErrCrashFetch)
+	}
+	if !strings.HasPrefix(err.Error(), "select stable release: errorCount: get error count") {
+		t.Errorf("run() error = %v, want the message kept", err)
+	}
+}
+
+func TestErrorCountNoCache(t *testing.T) {
+	cacheControl := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cacheControl = r.Header.Get("Cache-Control")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	oldCrash, oldClient, oldNoCache := crashReportURL, crashClient, crashNoCache
+	defer func() { crashReportURL, crashClient, crashNoCache = oldCrash, oldClient, oldNoCache }()
+	crashReportURL = server.URL
+	crashClient = server.Client()
+
+	for _, noCache := range []bool{false, true} {
+		crashNoCache = noCache
+		_, err := errorCount(context.Background(), "2.0.0")
+		if err != nil {
+			t.Fatalf("errorCount() error = %v", err)
+		}
+		if (cacheControl == "no-cache") != noCache {
+			t.Errorf("crash-no-cache %t: Cache-Control = %q", noCache, cacheControl)
+		}
+	}
+}
+
+func TestErrorCountQuery(t *testing.T) {
+	query := url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"server_name":"a","server_version":"2.0.0+build1"}]`))
+	}))
+	defer server.Close()
+	oldCrash, oldClient := crashReportURL, crashClient
+	defer func() { crashReportURL, crashClient = oldCrash, oldClient }()
+	// a base url with its own query, and a version whose + must not decode as a space
+	crashReportURL = server.URL + "/crashes?token=abc"
+	crashClient = server.Client()
+
+	count, err := errorCount(context.Background(), "2.0.0+build1")
+	if err != nil {
+		t.Fatalf("errorCount() error = %v", err)
+	}
+	if query.Get("token") != "abc" || query.Get("version") != "2.0.0+build1" {
+		t.Errorf("query = %v, want token abc and version 2.0.0+build1", query)
+	}
+	if count != 1 {
+		t.Errorf("errorCount() = %d, want 1", count)
+	}
+}
+
+func TestCrashCounts(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"server_name":"a","server_version":"2.0.0"}]`))
+	}))
+	defer server.Close()
+	oldCrash, oldClient := crashReportURL, crashClient
+	defer func() { crashReportURL, crashClient = oldCrash, oldClient }()
+	crashReportURL = server.URL
+	crashClient = server.Client()
+
+	counts := newCrashCounts()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count, err := counts.get(context.Background(), "2.0.0")
+			if err != nil || count != 1 {
+				t.Errorf("get() = %d, %v, want 1", count, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests.Load() != 1 {
+		t.Errorf("made %d requests, want 1", requests.Load())
+	}
+}
+
+func TestRunPing(t *testing.T) {
+	newTestServer(t, nil, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.ping = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	_, err = os.Stat("bin")
+	if !os.IsNotExist(err) {
+		t.Errorf("ping created the out dir, stat error = %v", err)
+	}
+
+	crashReportURL = "http://127.0.0.1:1/crashes"
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "spire unreachable") {
+		t.Errorf("run() error = %v, want spire unreachable", err)
+	}
+}
+
+func TestRunList(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}, map[string][]testCrash{
+		"1.9.0": {{ServerName: "a"}},
+	})
+	chdirTemp(t)
+
+	rules := release.DefaultOptions()
+	rules.MaxCrashServers = 1
+	rules.CrashCount = func(version string) (int, error) {
+		return errorCount(context.Background(), version)
+	}
+	opts := testOptions()
+	opts.withCrash = true
+	opts.format = "json"
+	out := &bytes.Buffer{}
+	err := runList(context.Background(), opts, rules, out)
+	if err != nil {
+		t.Fatalf("runList() error = %v", err)
+	}
+	entries := []*listEntryJson{}
+	err = json.Unmarshal(out.Bytes(), &entries)
+	if err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("listed %d releases, want 2", len(entries))
+	}
+	if entries[0].Tag != "v2.0.0" || entries[0].Qualifies {
+		t.Errorf("entries[0] = %+v, want v2.0.0 failing the age gate", entries[0])
+	}
+	if entries[1].Crashes == nil || *entries[1].Crashes != 1 || !entries[1].Qualifies {
+		t.Errorf("entries[1] = %+v, want v1.9.0 qualifying with 1 crash", entries[1])
+	}
+
+	opts.withCrash = false
+	opts.format = "txt"
+	out.Reset()
+	err = runList(context.Background(), opts, rules, out)
+	if err != nil {
+		t.Fatalf("runList() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "TAG") || !strings.Contains(lines[1], "FAIL") {
+		t.Errorf("list table =\n%s", out.String())
+	}
+	_, err = os.Stat("bin")
+	if !os.IsNotExist(err) {
+		t.Errorf("list created the out dir, stat error = %v", err)
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.dryRun = true
+	result, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(result.repos) != 1 {
+		t.Fatalf("run() returned %d repos, want 1", len(result.repos))
+	}
+	repo := result.repos[0]
+	if repo.stable().TagName != "v1.9.0" || repo.selected["unstable"].TagName != "v2.0.0" || repo.usedFallback {
+		t.Errorf("selected %v, used fallback %t, want stable v1.9.0 and unstable v2.0.0 without the fallback", repo.selected, repo.usedFallback)
+	}
+	reasons := map[string]release.Reason{}
+	for _, d := range repo.decisions {
+		if d.channel == "stable" {
+			reasons[d.decision.Release.TagName] = d.decision.Reason
+		}
+	}
+	want := map[string]release.Reason{"v2.0.0": release.ReasonTooNew, "v1.9.0": release.ReasonSelected}
+	if !reflect.DeepEqual(reasons, want) {
+		t.Errorf("stable decisions = %v, want %v", reasons, want)
+	}
+}
+
+func TestRunListClock(t *testing.T) {
+	day := 24 * time.Hour
+	rel := testRelease("v1.9.0", 10*day, "Fix login")
+	newTestServer(t, []*release.Release{rel}, map[string][]testCrash{
+		// filed 2 days after the release, a day before the clock's now
+		"1.9.0": {{ServerName: "a", CreatedAt: time.Now().Add(-8 * day).UTC().Format(time.RFC3339)}},
+	})
+	chdirTemp(t)
+	oldWindow, oldNow, oldGithubClient, oldCrashClient := crashWindow, crashNow, githubClient, crashClient
+	defer func() {
+		crashWindow, crashNow, githubClient, crashClient = oldWindow, oldNow, oldGithubClient, oldCrashClient
+	}()
+	githubClient, crashClient = http.DefaultClient, http.DefaultClient
+
+	// the clock is 3 days after the release, so the age column and the age gate agree it's too new
+	publishedAt, _ := release.ParseTimestamp(rel.PublishedAt)
+	rules := release.DefaultOptions()
+	rules.Now = func() time.Time { return publishedAt.Add(3 * day) }
+	crashWindow, crashNow = 2*day, rules.CurrentTime
+	rules.CrashCount = func(version string) (int, error) {
+		return errorCount(context.Background(), version)
+	}
+	opts := testOptions()
+	opts.withCrash = true
+	opts.format = "json"
+	out := &bytes.Buffer{}
+	err := runList(context.Background(), opts, rules, out)
+	if err != nil {
+		t.Fatalf("runList() error = %v", err)
+	}
+	entries := []*listEntryJson{}
+	err = json.Unmarshal(out.Bytes(), &entries)
+	if err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].AgeHours != 72 || entries[0].Qualifies {
+		t.Fatalf("entries = %+v, want v1.9.0 72 hours old failing the age gate", entries)
+	}
+	// the report is within the crash window ending at the clock's now, not the wall clock's
+	if entries[0].Crashes == nil || *entries[0].Crashes != 1 {
+		t.Errorf("crashes = %v, want 1 within the window", entries[0].Crashes)
+	}
+}
+
+func TestRunPreferGithubLatest(t *testing.T) {
+	day := 24 * time.Hour
+	releases := []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/eqemu/server/releases", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(releases)
+	})
+	// a maintainer marked the older release latest by hand
+	mux.HandleFunc("/repos/eqemu/server/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(releases[1])
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.skipCrashCheck = true
+	for _, prefer := range []bool{false, true} {
+		opts.preferGithubLatest = prefer
+		_, err := run(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		want := "v2.0.0"
+		if prefer {
+			want = "v1.9.0"
+		}
+		if latest := readOutput(t, "bin/latest.txt"); latest != want {
+			t.Errorf("prefer %t: latest.txt = %q, want %q", prefer, latest, want)
+		}
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = oldStdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestRunOutputStdout(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}, nil)
+	chdirTemp(t)
+
+	for _, format := range []string{"txt", "json"} {
+		opts := testOptions()
+		opts.output = "stdout"
+		opts.format = format
+		var err error
+		out := captureStdout(t, func() {
+			_, err = run(context.Background(), opts)
+		})
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		if format == "txt" && out != "v1.9.0\nv2.0.0\n" {
+			t.Errorf("txt output = %q, want the stable and latest tags", out)
+		}
+		selection := &selectionJson{}
+		if format == "json" && (json.Unmarshal([]byte(out), selection) != nil || selection.Stable.TagName != "v1.9.0") {
+			t.Errorf("json output = %q, want the selection", out)
+		}
+	}
+	entries, err := os.ReadDir(".")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("output stdout created %v, want no files", entries)
+	}
+
+	opts := testOptions()
+	opts.output = "stdout"
+	opts.notifyWebhook = "http://127.0.0.1:1/hook"
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "notify-webhook") {
+		t.Errorf("run() error = %v, want notify-webhook rejected with output stdout", err)
+	}
+}
+
+func TestRunReleasesFile(t *testing.T) {
+	day := 24 * time.Hour
+	chdirTemp(t)
+	data, err := json.Marshal([]*release.Release{
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	err = os.WriteFile("releases.json", data, 0644)
+	if err != nil {
+		t.Fatalf("write releases: %v", err)
+	}
+
+	opts := testOptions()
+	opts.releasesFile = "releases.json"
+	opts.skipCrashCheck = true
+	// nothing is listening, so any request fails the run
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	opts.githubAPIBase = "http://127.0.0.1:1"
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	stable := readOutput(t, "bin/stable.txt")
+	if stable != "v1.9.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v1.9.0")
+	}
+}
+
+func TestRunDuplicateTags(t *testing.T) {
+	day := 24 * time.Hour
+	// v2.0.0 was deleted and re-created yesterday, so only the new one is considered and it's too young
+	releases := []*release.Release{
+		testRelease("v2.0.0", 20*day, "Fix zone crash"),
+		testRelease("v2.0.0", 1*day, "Fix zone crash again"),
+		testRelease("v1.9.0", 30*day, "Fix login"),
+	}
+	unique := uniqueTags(releases)
+	if len(unique) != 2 || unique[0] != releases[1] || unique[1] != releases[2] {
+		t.Errorf("uniqueTags() = %v, want the re-created v2.0.0 and v1.9.0", unique)
+	}
+
+	newTestServer(t, releases, nil)
+	chdirTemp(t)
+	_, err := run(context.Background(), testOptions())
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if stable := readOutput(t, "bin/stable.txt"); stable != "v1.9.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v1.9.0")
+	}
+}
+
+func TestRunStableCount(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.1.0", 10*day, "Fix zone crash"),
+		testRelease("v2.0.0", 15*day, "New zone"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+		testRelease("v1.8.0", 25*day, "Fix spells"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.stableCount = 2
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if stable := readOutput(t, "bin/stable.txt"); stable != "v2.1.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v2.1.0")
+	}
+	if candidates := readOutput(t, "bin/stable-candidates.txt"); candidates != "v2.1.0\nv1.9.0" {
+		t.Errorf("stable-candidates.txt = %q, want %q", candidates, "v2.1.0\nv1.9.0")
+	}
+
+	opts.format = "json"
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	data, err := os.ReadFile("bin/selection.json")
+	if err != nil {
+		t.Fatalf("read selection.json: %v", err)
+	}
+	selection := &selectionJson{}
+	err = json.Unmarshal(data, selection)
+	if err != nil {
+		t.Fatalf("decode selection.json: %v", err)
+	}
+	tags := []string{}
+	for _, candidate := range selection.StableCandidates {
+		tags = append(tags, candidate.TagName)
+	}
+	if !reflect.DeepEqual(tags, []string{"v2.1.0", "v1.9.0"}) {
+		t.Errorf("stable_candidates = %v, want [v2.1.0 v1.9.0]", tags)
+	}
+}
+
+func TestRunMinAdoption(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, nil)
+	running := map[string][]testCrash{
+		"2.0.0": {{ServerName: "a"}},
+		// b is listed twice but counted once
+		"1.9.0": {{ServerName: "a"}, {ServerName: "b"}, {ServerName: "b"}},
+	}
+	adoption := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.URL.Query().Get("version")
+		payloads := []testCrash{}
+		for _, payload := range running[version] {
+			payload.ServerVersion = version
+			payloads = append(payloads, payload)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(payloads)
+	}))
+	defer adoption.Close()
+	oldAdoption := adoptionURL
+	defer func() { adoptionURL = oldAdoption }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.minAdoption = 2
+	_, err := run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "adoption-url") {
+		t.Errorf("run() without adoption-url error = %v, want it required", err)
+	}
+
+	opts.adoptionURL = adoption.URL
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	// v2.0.0 has no crash reports, but only because one server is running it
+	if stable := readOutput(t, "bin/stable.txt"); stable != "v1.9.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v1.9.0")
+	}
+}
+
+func TestRunMaxBodyKB(t *testing.T) {
+	day := 24 * time.Hour
+	// the only fix in v2.0.0 is past the first KB of its body
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Improve docs\n"+strings.Repeat("x", 1024)+"\nFix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, nil)
+	t.Cleanup(func() { maxBodyBytes = 0 })
+
+	tests := []struct {
+		name      string
+		maxBodyKB int
+		format    string
+		want      string
+	}{
+		{name: "whole bodies", want: "v2.0.0"},
+		{name: "truncated bodies", maxBodyKB: 1, want: "v1.9.0"},
+		{name: "json keeps whole bodies", maxBodyKB: 1, format: "json", want: "v2.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			opts := testOptions()
+			opts.maxBodyKB = tt.maxBodyKB
+			if tt.format != "" {
+				opts.format = tt.format
+			}
+			result, err := run(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			stable := result.repos[0].stable()
+			if stable == nil || stable.TagName != tt.want {
+				t.Errorf("stable = %v, want %s", stable, tt.want)
+			}
+		})
+	}
+
+	if got := truncateBody("Fix crash", 3); got != "Fix" {
+		t.Errorf("truncateBody() = %q, want %q", got, "Fix")
+	}
+	// a multibyte character straddling the limit is dropped rather than split
+	if got := truncateBody("Fix é", 5); got != "Fix " {
+		t.Errorf("truncateBody() = %q, want %q", got, "Fix ")
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}, nil)
+	chdirTemp(t)
+	err := os.Mkdir("bin", 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	err = os.WriteFile("bin/latest.txt", []byte("v2.0.0"), 0644)
+	if err != nil {
+		t.Fatalf("write latest: %v", err)
+	}
+	err = os.WriteFile("bin/stable.txt", []byte("v1.9.0"), 0644)
+	if err != nil {
+		t.Fatalf("write stable: %v", err)
+	}
+
+	opts := testOptions()
+	opts.diff = true
+	_, err = run(context.Background(), opts)
+	if !errors.Is(err, errChanged) {
+		t.Fatalf("run() error = %v, want %v", err, errChanged)
+	}
+	if readOutput(t, "bin/stable.txt") != "v1.9.0" {
+		t.Errorf("diff rewrote stable.txt")
+	}
+
+	// a state written by an older build isn't a change when latest and stable are the same
+	err = os.WriteFile("bin/stable.txt", []byte("v2.0.0"), 0644)
+	if err != nil {
+		t.Fatalf("write stable: %v", err)
+	}
+	err = os.WriteFile("bin/"+stateFile, []byte(`{"stable":"v2.0.0","unstable":"v2.0.0","version":"v0.9.0"}`), 0644)
+	if err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v, want no change", err)
+	}
+
+	buf := &bytes.Buffer{}
+	writeDiff(buf, "bin/stable.txt", "v1.9.0", "v2.0.0")
+	want := "--- bin/stable.txt\n+++ bin/stable.txt\n@@ -1 +1 @@\n-v1.9.0\n+v2.0.0\n"
+	if buf.String() != want {
+		t.Errorf("writeDiff() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestErrorCountNotJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>maintenance</html>"))
+	}))
+	defer server.Close()
+	oldCrash, oldClient := crashReportURL, crashClient
+	defer func() { crashReportURL, crashClient = oldCrash, oldClient }()
+	crashReportURL = server.URL
+	crashClient = server.Client()
+
+	_, err := errorCount(context.Background(), "2.0.0")
+	if err == nil || !strings.Contains(err.Error(), "expected json") {
+		t.Errorf("errorCount() error = %v, want an expected json error", err)
+	}
+}
+
+func TestRunRetriesEmptyBody(t *testing.T) {
+	day := 24 * time.Hour
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only the releases listing is retried, github's latest release isn't served
+		if r.URL.Path != "/repos/eqemu/server/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		if requests == 1 {
+			w.Write([]byte("  \n"))
+			return
+		}
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.retries = 2
+	opts.skipCrashCheck = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want 2", requests)
+	}
+	if readOutput(t, "bin/stable.txt") != "v2.0.0" {
+		t.Errorf("stable.txt not written after retrying")
+	}
+}
+
+func TestRunRetryAfter(t *testing.T) {
+	day := 24 * time.Hour
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only the releases listing is retried, github's latest release isn't served
+		if r.URL.Path != "/repos/eqemu/server/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, `{"message":"You have exceeded a secondary rate limit"}`, http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.retries = 2
+	opts.maxRetryAfter = "10ms"
+	opts.skipCrashCheck = true
+	start := time.Now()
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want 2", requests)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retry waited %s, want it capped at max-retry-after", elapsed)
+	}
+}
+
+func TestRunTokenFile(t *testing.T) {
+	day := 24 * time.Hour
+	authorization := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+	t.Setenv("GITHUB_TOKEN", "from-env")
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.skipCrashCheck = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if authorization != "Bearer from-env" {
+		t.Errorf("Authorization = %q, want the GITHUB_TOKEN token", authorization)
+	}
+
+	err = os.WriteFile("token", []byte("from-file\n"), 0600)
+	if err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+	opts.tokenFile = "token"
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if authorization != "Bearer from-file" {
+		t.Errorf("Authorization = %q, want the token file's token", authorization)
+	}
+
+	err = os.WriteFile("token", []byte(" \n"), 0600)
+	if err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "token file token is empty") {
+		t.Fatalf("run() error = %v, want an empty token file error", err)
+	}
+}
+
+func TestWriteVersion(t *testing.T) {
+	oldVersion, oldCommit, oldDate := buildVersion, buildCommit, buildDate
+	defer func() { buildVersion, buildCommit, buildDate = oldVersion, oldCommit, oldDate }()
+	buildVersion, buildCommit, buildDate = "v1.2.3", "abc123", "2024-01-02"
+
+	out := &bytes.Buffer{}
+	err := writeVersion(out, false)
+	if err != nil {
+		t.Fatalf("writeVersion() error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "eqemu-pack-server v1.2.3 commit abc123 built 2024-01-02 go") {
+		t.Errorf("version = %q", out.String())
+	}
+	out.Reset()
+	err = writeVersion(out, true)
+	if err != nil {
+		t.Fatalf("writeVersion() error = %v", err)
+	}
+	build := &buildInfoJson{}
+	err = json.Unmarshal(out.Bytes(), build)
+	if err != nil || build.Version != "v1.2.3" || build.Commit != "abc123" || build.Date != "2024-01-02" {
+		t.Errorf("version json = %s, error %v", out.String(), err)
+	}
+	if defaultUserAgent() != "eqemu-pack-server/v1.2.3" {
+		t.Errorf("defaultUserAgent() = %q, want the build version", defaultUserAgent())
+	}
+}
+
+func TestRunUserAgent(t *testing.T) {
+	day := 24 * time.Hour
+	userAgents := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents[r.URL.Path] = r.UserAgent()
+		if r.URL.Path == "/crashes" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[]"))
+			return
+		}
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.crashAPIBase = server.URL + "/crashes"
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	for path, userAgent := range userAgents {
+		if !strings.HasPrefix(userAgent, "eqemu-pack-server/") {
+			t.Errorf("%s User-Agent = %q, want eqemu-pack-server/<version>", path, userAgent)
+		}
+	}
+
+	opts.userAgent = "my-deploy/1.0"
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	for _, path := range []string{"/repos/eqemu/server/releases", "/crashes"} {
+		if userAgents[path] != "my-deploy/1.0" {
+			t.Errorf("%s User-Agent = %q, want my-deploy/1.0", path, userAgents[path])
+		}
+	}
+}
+
+func TestRunTLSOptions(t *testing.T) {
+	day := 24 * time.Hour
+	releases := []*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")}
+	newTestServer(t, releases, nil)
+	// a self-signed server that only speaks up to TLS 1.2, like a staging Spire
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/crashes" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("[]"))
+			return
+		}
+		json.NewEncoder(w).Encode(releases)
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	githubURL := githubAPIBase
+	defer func() { githubAPIBase = githubURL }()
+
+	tests := []struct {
+		name          string
+		minVersion    string
+		skipTLSVerify bool
+		// githubTLS fetches releases from the self-signed server too
+		githubTLS bool
+		wantErr   string
+	}{
+		{name: "verified by default", wantErr: "certificate"},
+		{name: "skip verify", skipTLSVerify: true},
+		// the GitHub token must never go over an unverified connection
+		{name: "skip verify is only for crash reports", skipTLSVerify: true, githubTLS: true, wantErr: "certificate"},
+		{name: "min version met", minVersion: "1.2", skipTLSVerify: true},
+		{name: "min version not met", minVersion: "1.3", skipTLSVerify: true, wantErr: "protocol version"},
+		{name: "unknown min version", minVersion: "1.4", wantErr: "unknown tls-min-version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			opts := testOptions()
+			opts.githubAPIBase = githubURL
+			if tt.githubTLS {
+				opts.githubAPIBase = server.URL
+			}
+			opts.crashAPIBase = server.URL + "/crashes"
+			opts.tlsMinVersion = tt.minVersion
+			opts.insecureSkipTLSVerify = tt.skipTLSVerify
+			_, err := run(context.Background(), opts)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("run() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunNotModifiedCache(t *testing.T) {
+	day := 24 * time.Hour
+	bodies, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/eqemu/server/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		bodies++
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.skipCrashCheck = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	err = os.Remove("bin/stable.txt")
+	if err != nil {
+		t.Fatalf("remove stable: %v", err)
+	}
+
+	// the second run sends the cached etag, gets a 304 and selects from the cache
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() with cache error = %v", err)
+	}
+	if bodies != 1 || notModified != 1 {
+		t.Errorf("served %d listings and %d not modified, want 1 of each", bodies, notModified)
+	}
+	if readOutput(t, "bin/stable.txt") != "v2.0.0" {
+		t.Errorf("stable.txt not written from the cached releases")
+	}
+
+	opts.noCache = true
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() with no-cache error = %v", err)
+	}
+	if bodies != 2 {
+		t.Errorf("served %d listings, want no-cache to fetch the listing again", bodies)
+	}
+}
+
+func TestRunMetricsPush(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.1.0", 1*day, "Fix spells"),
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, nil)
+	var method, contentType, body string
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, contentType = r.Method, r.Header.Get("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer pushgateway.Close()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.metricsPush = pushgateway.URL + "/metrics/job/eqemu_release"
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if method != http.MethodPut || contentType != "text/plain; version=0.0.4" {
+		t.Errorf("pushed with %s and Content-Type %q, want PUT and the text exposition format", method, contentType)
+	}
+	for _, want := range []string{
+		"# TYPE eqemu_release_considered gauge\neqemu_release_considered 2\n",
+		"eqemu_release_decisions{reason=\"SELECTED\"} 1\n",
+		"eqemu_release_decisions{reason=\"TOO_NEW\"} 1\n",
+		"# TYPE eqemu_release_stable_age_seconds gauge\neqemu_release_stable_age_seconds ",
+		"# TYPE eqemu_release_stable_crash_servers gauge\neqemu_release_stable_crash_servers 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("pushed metrics missing %q:\n%s", want, body)
+		}
+	}
+	var age int64
+	_, after, _ := strings.Cut(body, "\neqemu_release_stable_age_seconds ")
+	fmt.Sscan(after, &age)
+	if age < 864000 || age > 864000+60 {
+		t.Errorf("eqemu_release_stable_age_seconds = %d, want about 10 days", age)
+	}
+}
+
+func TestRunStaleCache(t *testing.T) {
+	day := 24 * time.Hour
+	unavailable := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unavailable {
+			http.Error(w, "unicorn", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode([]*release.Release{testRelease("v2.0.0", 10*day, "Fix zone crash")})
+	}))
+	defer server.Close()
+	oldGithub := githubAPIBase
+	defer func() { githubAPIBase = oldGithub }()
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.githubAPIBase = server.URL
+	opts.skipCrashCheck = true
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	unavailable = true
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() with cache error = %v", err)
+	}
+	if readOutput(t, "bin/stable.txt") != "v2.0.0" {
+		t.Errorf("stable.txt not written from the cached releases")
+	}
+
+	opts.noStale = true
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("run() with no-stale error = %v, want 503", err)
+	}
+}
+
+func TestRunReposFile(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}, nil)
+	chdirTemp(t)
+	err := os.WriteFile("repos.txt", []byte("# servers\neqemu/server\n\nmissing/server\n"), 0644)
+	if err != nil {
+		t.Fatalf("write repos: %v", err)
+	}
+
+	opts := testOptions()
+	opts.reposFile = "repos.txt"
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "missing/server") {
+		t.Fatalf("run() error = %v, want an error for missing/server", err)
+	}
+	// the failing repo doesn't stop the others
+	stable := readOutput(t, "bin/eqemu/server/stable.txt")
+	if stable != "v2.0.0" {
+		t.Errorf("eqemu/server stable.txt = %q, want %q", stable, "v2.0.0")
+	}
+}
+
+func TestRunReposFileExitCode(t *testing.T) {
+	day := 24 * time.Hour
+	// eqemu/server has no release old enough for stable or the fallback, missing/server is a 404
+	newTestServer(t, []*release.Release{
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+	}, nil)
+	chdirTemp(t)
+	err := os.WriteFile("repos.txt", []byte("eqemu/server\nmissing/server\n"), 0644)
+	if err != nil {
+		t.Fatalf("write repos: %v", err)
+	}
+
+	opts := testOptions()
+	opts.reposFile = "repos.txt"
+	_, err = run(context.Background(), opts)
+	if !errors.Is(err, release.ErrNoRelease) {
+		t.Fatalf("run() error = %v, want it to include %v", err, release.ErrNoRelease)
+	}
+	if code := exitCode(err); code != exitError {
+		t.Errorf("exitCode() = %d, want %d since a repo failed", code, exitError)
+	}
+
+	err = os.WriteFile("repos.txt", []byte("eqemu/server\n"), 0644)
+	if err != nil {
+		t.Fatalf("write repos: %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if code := exitCode(err); code != exitNoRelease {
+		t.Errorf("exitCode() = %d, want %d when no repo has a release", code, exitNoRelease)
+	}
+}
+
+func TestRunChannels(t *testing.T) {
+	day := 24 * time.Hour
+	prerelease := testRelease("v2.1.0-rc1", 1*day, "Fix crash")
+	prerelease.Prerelease = true
+	newTestServer(t, []*release.Release{
+		prerelease,
+		testRelease("v2.0.0", 2*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}, nil)
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.channels = "bleeding,stable"
+	_, err := run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	bleeding := readOutput(t, "bin/bleeding.txt")
+	if bleeding != "v2.1.0-rc1" {
+		t.Errorf("bleeding.txt = %q, want %q", bleeding, "v2.1.0-rc1")
+	}
+	stable := readOutput(t, "bin/stable.txt")
+	if stable != "v1.9.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v1.9.0")
+	}
+	_, err = os.Stat("bin/prerelease.txt")
+	if !os.IsNotExist(err) {
+		t.Errorf("prerelease.txt written without -emit-prerelease, stat error = %v", err)
+	}
+	_, err = os.Stat("bin/latest.txt")
+	if !os.IsNotExist(err) {
+		t.Errorf("latest.txt written for an unselected channel, stat error = %v", err)
+	}
+}
+
+func TestRunChannelRules(t *testing.T) {
+	day := 24 * time.Hour
+	newTestServer(t, []*release.Release{
+		testRelease("v2.1.0", 1*day, "Fix crash"),
+		testRelease("v2.0.0", 5*day, "Fix zone crash"),
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}, map[string][]testCrash{"2.0.0": {{ServerName: "a"}}})
+	chdirTemp(t)
+
+	opts := testOptions()
+	opts.channelRules = channelRulesFlag{}
+	err := opts.channelRules.Set("unstable.min-age=48h")
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	// unstable has an age gate but no crash gate, stable keeps its own rules
+	if latest := readOutput(t, "bin/latest.txt"); latest != "v2.0.0" {
+		t.Errorf("latest.txt = %q, want %q", latest, "v2.0.0")
+	}
+	if stable := readOutput(t, "bin/stable.txt"); stable != "v1.9.0" {
+		t.Errorf("stable.txt = %q, want %q", stable, "v1.9.0")
+	}
+
+	err = opts.channelRules.Set("unstable.max-crash-servers=0")
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if latest := readOutput(t, "bin/latest.txt"); latest != "v1.9.0" {
+		t.Errorf("latest.txt with a crash gate = %q, want %q", latest, "v1.9.0")
+	}
+
+	err = opts.channelRules.Set("bleeding.min-age=24h")
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	_, err = run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "bleeding") {
+		t.Errorf("run() with a rule for an unselected channel error = %v, want it rejected", err)
+	}
+}
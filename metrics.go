@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// runMetrics describes a run for pushing to a Prometheus Pushgateway
+type runMetrics struct {
+	// considered is how many releases were fetched
+	considered int
+	// decisions counts releases by the reason they were skipped or selected
+	decisions map[release.Reason]int
+	// stableAge is how long ago the stable release was published
+	stableAge time.Duration
+	// stableErrorCount is the crash count observed for the stable release, nil if it wasn't checked
+	stableErrorCount *int
+}
+
+// exposition renders the metrics in the Prometheus text exposition format
+func (m *runMetrics) exposition() []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "# TYPE eqemu_release_considered gauge")
+	fmt.Fprintf(buf, "eqemu_release_considered %d\n", m.considered)
+
+	reasons := []string{}
+	for reason := range m.decisions {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	fmt.Fprintln(buf, "# TYPE eqemu_release_decisions gauge")
+	for _, reason := range reasons {
+		fmt.Fprintf(buf, "eqemu_release_decisions{reason=%q} %d\n", reason, m.decisions[release.Reason(reason)])
+	}
+
+	fmt.Fprintln(buf, "# TYPE eqemu_release_stable_age_seconds gauge")
+	fmt.Fprintf(buf, "eqemu_release_stable_age_seconds %d\n", int64(m.stableAge.Seconds()))
+
+	if m.stableErrorCount != nil {
+		fmt.Fprintln(buf, "# TYPE eqemu_release_stable_crash_servers gauge")
+		fmt.Fprintf(buf, "eqemu_release_stable_crash_servers %d\n", *m.stableErrorCount)
+	}
+	return buf.Bytes()
+}
+
+// pushMetrics replaces the metrics of the Pushgateway group at url,
+// e.g. http://pushgateway:9091/metrics/job/eqemu_release
+func pushMetrics(ctx context.Context, url string, m *runMetrics) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(m.exposition()))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push metrics: unexpected status %s", resp.Status)
+	}
+	return nil
+}
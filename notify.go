@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// maxChangelogExcerpt is how much of a release body is included in a notification
+const maxChangelogExcerpt = 500
+
+// promotionJson is posted to the notify webhook when stable changes. Text and Content carry
+// a summary so Slack and Discord incoming webhooks can display it as is.
+type promotionJson struct {
+	Repo      string `json:"repo"`
+	OldStable string `json:"old_stable"`
+	NewStable string `json:"new_stable"`
+	Name      string `json:"name"`
+	Changelog string `json:"changelog"`
+	Text      string `json:"text"`
+	Content   string `json:"content"`
+	// Version is the version of the tool that sent the notification
+	Version string `json:"version"`
+}
+
+// changelogExcerpt returns the start of body, cut at a line break where possible
+func changelogExcerpt(body string) string {
+	body = strings.TrimSpace(body)
+	if len(body) <= maxChangelogExcerpt {
+		return body
+	}
+	excerpt := body[:maxChangelogExcerpt]
+	if i := strings.LastIndex(excerpt, "\n"); i > 0 {
+		excerpt = excerpt[:i]
+	}
+	return excerpt + "\n..."
+}
+
+// notifyPromotion posts a promotionJson for stable replacing oldStable in repo to url
+func notifyPromotion(ctx context.Context, url string, repo string, oldStable string, stable *release.Release) error {
+	summary := fmt.Sprintf("%s stable changed from %s to %s", repo, oldStable, stable.TagName)
+	data, err := json.Marshal(&promotionJson{
+		Repo:      repo,
+		OldStable: oldStable,
+		NewStable: stable.TagName,
+		Name:      stable.Name,
+		Changelog: changelogExcerpt(stable.Body),
+		Text:      summary,
+		Content:   summary,
+		Version:   currentBuild().Version,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notify: unexpected status %s: %s", resp.Status, bodySnippet(body))
+	}
+	return nil
+}
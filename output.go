@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// selectionJson is written to bin/selection.json when using -format json
+type selectionJson struct {
+	// each channel is omitted when it wasn't selected
+	Stable   *selectedReleaseJson `json:"stable,omitempty"`
+	Unstable *selectedReleaseJson `json:"unstable,omitempty"`
+	Bleeding *selectedReleaseJson `json:"bleeding,omitempty"`
+	// Prerelease is the newest prerelease, written with -emit-prerelease
+	Prerelease   *selectedReleaseJson `json:"prerelease,omitempty"`
+	UsedFallback bool                 `json:"used_fallback"`
+	// Decisions counts the stable channel's releases by the reason they were skipped or selected
+	Decisions map[release.Reason]int `json:"decisions"`
+	// Summary is a one line description of the decisions, as logged at the end of the run
+	Summary string `json:"summary"`
+	// StableCandidates are the stable candidates in order of preference, written with -stable-count
+	StableCandidates []*selectedReleaseJson `json:"stable_candidates,omitempty"`
+	// Version is the version of the tool that made the selection
+	Version string `json:"version"`
+}
+
+// selectedReleaseJson describes a selected release
+type selectedReleaseJson struct {
+	Name        string `json:"name"`
+	TagName     string `json:"tag_name"`
+	PublishedAt string `json:"published_at"`
+	// ErrorCount is the crash report count observed for the release, omitted if it wasn't checked
+	ErrorCount *int                  `json:"error_count,omitempty"`
+	Changelog  []release.ChangeEntry `json:"changelog"`
+}
+
+// marshalOutput encodes v for an output file, indented with a trailing newline if pretty.
+// Map keys are sorted by encoding/json, so the same selection always encodes the same way.
+func marshalOutput(v any, pretty bool) ([]byte, error) {
+	if !pretty {
+		return json.Marshal(v)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// outputFile is a file written at the end of a run
+type outputFile struct {
+	path string
+	data []byte
+}
+
+// printOutputs writes the data of each output to w on its own line, in place of writing the files
+func printOutputs(w io.Writer, outputs []outputFile) error {
+	for _, output := range outputs {
+		data := output.data
+		if !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data[:len(data):len(data)], '\n')
+		}
+		_, err := w.Write(data)
+		if err != nil {
+			return fmt.Errorf("write stdout: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkWritable creates dir and a temporary file in it, so an unusable out dir fails
+// before any network work rather than after
+func checkWritable(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("out dir %s isn't usable: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("out dir %s isn't writable, check its permissions or that it isn't a read-only mount: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeOutputs creates dir and writes each output. Outputs whose file already holds
+// the same data are left alone, so mtimes only change with the selection, unless force is set.
+func writeOutputs(dir string, outputs []outputFile, force bool) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	for _, output := range outputs {
+		if !force {
+			existing, err := os.ReadFile(output.path)
+			if err == nil && bytes.Equal(existing, output.data) {
+				logger.Info("unchanged, not writing file", "path", output.path)
+				continue
+			}
+		}
+		err = writeFileAtomic(output.path, output.data, 0644)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", output.path, err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so readers see either the old or the new contents and never a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// outputValue returns what a txt file contains for rel, its name with select-by name or
+// otherwise its tag. A blank name falls back to the tag so the file is never empty.
+func outputValue(rel *release.Release, selectBy string) string {
+	if selectBy != "name" {
+		return rel.TagName
+	}
+	name := strings.TrimSpace(rel.Name)
+	if name == "" {
+		logger.Warn("release has no name, writing its tag", "tag", rel.TagName)
+		return rel.TagName
+	}
+	return name
+}
+
+// newSelectedReleaseJson describes rel along with its observed error count, if any.
+// A nil rel is described as nil.
+func newSelectedReleaseJson(rel *release.Release, errorCounts map[string]int) *selectedReleaseJson {
+	if rel == nil {
+		return nil
+	}
+	selected := &selectedReleaseJson{
+		Name:        rel.Name,
+		TagName:     rel.TagName,
+		PublishedAt: rel.PublishedAt,
+		Changelog:   release.ParseChangelog(rel.Body),
+	}
+	count, ok := errorCounts[rel.TagName]
+	if ok {
+		selected.ErrorCount = &count
+	}
+	return selected
+}
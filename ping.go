@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// runPing requests the GitHub API root and the Spire host, writing whether each is
+// reachable to w along with the latency and remaining GitHub rate limit
+func runPing(ctx context.Context, w io.Writer) error {
+	failed := []string{}
+
+	githubURL := githubAPIBase + "/"
+	status, header, latency, err := ping(ctx, githubClient, githubURL, true)
+	detail := ""
+	if err == nil {
+		detail = "rate limit remaining " + header.Get("X-RateLimit-Remaining")
+		if status == http.StatusUnauthorized {
+			err = fmt.Errorf("%d, the GitHub token was rejected", status)
+		}
+	}
+	if err != nil {
+		failed = append(failed, "github")
+		detail = err.Error()
+	}
+	writePingResult(w, "github", githubURL, latency, err, detail)
+
+	spire, err := url.Parse(crashReportURL)
+	if err != nil {
+		return fmt.Errorf("parse crash report url: %w", err)
+	}
+	spireURL := spire.Scheme + "://" + spire.Host + "/"
+	_, _, latency, err = ping(ctx, crashClient, spireURL, false)
+	detail = ""
+	if err != nil {
+		failed = append(failed, "spire")
+		detail = err.Error()
+	}
+	writePingResult(w, "spire", spireURL, latency, err, detail)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("ping: %s unreachable", strings.Join(failed, " and "))
+	}
+	return nil
+}
+
+// ping sends a single GET to pingURL, without retries, and reports how long the
+// response took. Server errors are returned as errors.
+func ping(ctx context.Context, c *http.Client, pingURL string, github bool) (int, http.Header, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return 0, nil, 0, fmt.Errorf("new request: %w", err)
+	}
+	if github {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if githubToken != "" {
+			req.Header.Set("Authorization", "Bearer "+githubToken)
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, nil, latency, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 500 {
+		return resp.StatusCode, resp.Header, latency, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, resp.Header, latency, nil
+}
+
+// writePingResult writes a line describing the outcome of pinging name
+func writePingResult(w io.Writer, name string, pingURL string, latency time.Duration, err error, detail string) {
+	result := "OK"
+	if err != nil {
+		result = "FAIL"
+	}
+	fmt.Fprintf(w, "%-7s %-4s %6dms  %s  %s\n", name, result, latency.Milliseconds(), pingURL, detail)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// parsePolicies builds the comma separated chain of built-in policies given to -policies,
+// in order. An empty value applies no policies.
+func parsePolicies(value string, minAge time.Duration, keywords []string, minFixes int, bodyRule *release.BodyRule) ([]release.SelectionPolicy, error) {
+	policies := []release.SelectionPolicy{}
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "age":
+			policies = append(policies, release.MinAgePolicy{MinAge: minAge})
+		case "keywords":
+			policies = append(policies, release.KeywordPolicy{Keywords: keywords, MinFixes: minFixes})
+		case "body":
+			policies = append(policies, release.BodyRulePolicy{Rule: bodyRule})
+		default:
+			return nil, fmt.Errorf("unknown policy %q, expected age, keywords or body", name)
+		}
+	}
+	return policies, nil
+}
+
+// hasPolicy reports whether the -policies value includes the policy called name
+func hasPolicy(value string, name string) bool {
+	for _, policy := range strings.Split(value, ",") {
+		if strings.TrimSpace(policy) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fixtureJson is a recorded response as stored under testdata, replayed by the tests
+type fixtureJson struct {
+	// URL is the request path and query, the host is dropped so fixtures replay from any server
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Link        string `json:"link,omitempty"`
+	Body        string `json:"body"`
+}
+
+// unsafeFixtureName matches the characters replaced in a fixture file name
+var unsafeFixtureName = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// fixtureName returns the file name a response to u is recorded under
+func fixtureName(u *url.URL) string {
+	name := strings.TrimPrefix(u.Path, "/")
+	if u.RawQuery != "" {
+		name += "?" + u.RawQuery
+	}
+	return unsafeFixtureName.ReplaceAllString(name, "_") + ".json"
+}
+
+// recorder is an http.RoundTripper saving each response it sees to dir as a fixture
+type recorder struct {
+	next http.RoundTripper
+	dir  string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("record %s: read body: %w", req.URL, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	fixture := &fixtureJson{
+		URL:         req.URL.RequestURI(),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+		Link:        resp.Header.Get("Link"),
+		Body:        string(data),
+	}
+	encoded, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("record %s: %w", req.URL, err)
+	}
+	err = os.MkdirAll(r.dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", r.dir, err)
+	}
+	path := filepath.Join(r.dir, fixtureName(req.URL))
+	err = writeFileAtomic(path, append(encoded, '\n'), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("record %s: %w", req.URL, err)
+	}
+	logger.Debug("recorded response", "url", req.URL.String(), "path", path)
+	return resp, nil
+}
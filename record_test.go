@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayServer serves the fixtures recorded in dir with -record, pointing GitHub and Spire at it
+func replayServer(t *testing.T, dir string) {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(filepath.Join(dir, fixtureName(r.URL)))
+		if err != nil {
+			t.Errorf("no fixture for %s: %v", r.URL, err)
+			http.NotFound(w, r)
+			return
+		}
+		fixture := &fixtureJson{}
+		err = json.Unmarshal(data, fixture)
+		if err != nil {
+			t.Fatalf("decode fixture for %s: %v", r.URL, err)
+		}
+		if fixture.ContentType != "" {
+			w.Header().Set("Content-Type", fixture.ContentType)
+		}
+		if fixture.ETag != "" {
+			w.Header().Set("ETag", fixture.ETag)
+		}
+		if fixture.Link != "" {
+			w.Header().Set("Link", strings.ReplaceAll(fixture.Link, "https://api.github.com", server.URL))
+		}
+		w.WriteHeader(fixture.Status)
+		w.Write([]byte(fixture.Body))
+	}))
+	t.Cleanup(server.Close)
+
+	oldGithub, oldCrash := githubAPIBase, crashReportURL
+	oldGithubClient, oldCrashClient := githubClient, crashClient
+	githubAPIBase = server.URL
+	crashReportURL = server.URL + "/api/v1/analytics/server-crash-reports"
+	githubClient, crashClient = server.Client(), server.Client()
+	t.Cleanup(func() {
+		githubAPIBase, crashReportURL = oldGithub, oldCrash
+		githubClient, crashClient = oldGithubClient, oldCrashClient
+	})
+}
+
+// TestReplayFixtures decodes the recorded responses, so a change to the GitHub or Spire schema
+// shows up once the fixtures are refreshed. It only relies on fields every response has.
+func TestReplayFixtures(t *testing.T) {
+	dir := "testdata/replay"
+	recorded, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(recorded) == 0 {
+		t.Skipf("no fixtures recorded in %s, record them with -no-cache -record %s", dir, dir)
+	}
+	replayServer(t, dir)
+
+	releases, err := githubReleases(context.Background(), "eqemu/server", nil)
+	if err != nil {
+		t.Fatalf("githubReleases() error = %v", err)
+	}
+	if len(releases) == 0 {
+		t.Fatal("githubReleases() returned no releases")
+	}
+	assets := 0
+	for _, rel := range releases {
+		_, err := time.Parse(time.RFC3339, rel.PublishedAt)
+		if rel.TagName == "" || err != nil || rel.TarballURL == "" || rel.ZipballURL == "" {
+			t.Errorf("release %+v is missing its tag, publish date or source archives", rel)
+		}
+		for _, asset := range rel.Assets {
+			assets++
+			if asset.Name == "" || asset.BrowserDownloadURL == "" {
+				t.Errorf("release %s asset %+v is missing its name or download url", rel.TagName, asset)
+			}
+		}
+	}
+	if assets == 0 {
+		t.Error("no release has assets, want at least one to check their decoding")
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join(dir, "api_v1_analytics_server-crash-reports_version_*.json"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no crash report fixtures in %s: %v", dir, err)
+	}
+	for _, fixture := range fixtures {
+		version := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fixture), "api_v1_analytics_server-crash-reports_version_"), ".json")
+		_, err := errorCount(context.Background(), version)
+		if err != nil {
+			t.Errorf("errorCount(%s) error = %v", version, err)
+		}
+	}
+}
+
+func TestReplayNotModified(t *testing.T) {
+	// a listing recorded while a cached etag was sent replays as a 304 to a request without one
+	dir := t.TempDir()
+	data, err := json.Marshal(&fixtureJson{URL: "/repos/eqemu/server/releases?per_page=100", Status: http.StatusNotModified})
+	if err != nil {
+		t.Fatalf("encode fixture: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "repos_eqemu_server_releases_per_page_100.json"), data, 0644)
+	if err != nil {
+		t.Fatalf("write fixture: %v", err)
+	}
+	replayServer(t, dir)
+
+	_, err = githubReleases(context.Background(), "eqemu/server", nil)
+	if err == nil || !strings.Contains(err.Error(), "not modified") {
+		t.Errorf("githubReleases() error = %v, want a not modified error", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"tag_name":"v2.0.0"}]`))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	c := &http.Client{Transport: &recorder{next: http.DefaultTransport, dir: dir}}
+
+	resp, err := c.Get(server.URL + "/repos/eqemu/server/releases?per_page=100")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "repos_eqemu_server_releases_per_page_100.json"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	fixture := &fixtureJson{}
+	err = json.Unmarshal(data, fixture)
+	if err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	if fixture.URL != "/repos/eqemu/server/releases?per_page=100" || fixture.Status != 200 || fixture.Body != `[{"tag_name":"v2.0.0"}]` {
+		t.Errorf("fixture = %+v", fixture)
+	}
+}
@@ -0,0 +1,198 @@
+package release
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BodyRule is a boolean expression matched against a release body, e.g.
+// "Fix" AND NOT "BREAKING". Quoted substrings are matched case-sensitively and
+// combined with AND, OR, NOT and parentheses; NOT binds tightest, then AND, then OR.
+type BodyRule struct {
+	text string
+	expr ruleExpr
+}
+
+// ParseBodyRule parses a body rule expression
+func ParseBodyRule(text string) (*BodyRule, error) {
+	tokens, err := tokenizeRule(text)
+	if err != nil {
+		return nil, fmt.Errorf("body rule %q: %w", text, err)
+	}
+	p := &ruleParser{tokens: tokens}
+	expr, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("body rule %q: %w", text, err)
+	}
+	return &BodyRule{text: text, expr: expr}, nil
+}
+
+// Match reports whether body satisfies the rule
+func (r *BodyRule) Match(body string) bool {
+	return r.expr.match(body)
+}
+
+func (r *BodyRule) String() string {
+	return r.text
+}
+
+type ruleExpr interface {
+	match(body string) bool
+}
+
+type ruleSubstring string
+
+func (e ruleSubstring) match(body string) bool {
+	return strings.Contains(body, string(e))
+}
+
+type ruleNot struct {
+	expr ruleExpr
+}
+
+func (e ruleNot) match(body string) bool {
+	return !e.expr.match(body)
+}
+
+type ruleAnd struct {
+	left, right ruleExpr
+}
+
+func (e ruleAnd) match(body string) bool {
+	return e.left.match(body) && e.right.match(body)
+}
+
+type ruleOr struct {
+	left, right ruleExpr
+}
+
+func (e ruleOr) match(body string) bool {
+	return e.left.match(body) || e.right.match(body)
+}
+
+// ruleToken is an operator, parenthesis or quoted substring, at offset in the rule
+type ruleToken struct {
+	text   string
+	quoted bool
+	offset int
+}
+
+// tokenizeRule splits a rule into tokens, operators must be uppercase
+func tokenizeRule(text string) ([]ruleToken, error) {
+	tokens := []ruleToken{}
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, ruleToken{text: string(c), offset: i})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at offset %d", i)
+			}
+			tokens = append(tokens, ruleToken{text: text[i+1 : i+1+end], quoted: true, offset: i})
+			i += end + 2
+		default:
+			end := strings.IndexAny(text[i:], " \t\n()\"'")
+			if end < 0 {
+				end = len(text) - i
+			}
+			word := text[i : i+end]
+			if word != "AND" && word != "OR" && word != "NOT" {
+				return nil, fmt.Errorf("unexpected %q at offset %d, substrings must be quoted", word, i)
+			}
+			tokens = append(tokens, ruleToken{text: word, offset: i})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// ruleParser is a recursive descent parser over the tokens of a rule
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+// accept consumes the next token if it's the operator or parenthesis op
+func (p *ruleParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// unexpected returns an error for the next token, or the end of the rule
+func (p *ruleParser) unexpected() error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("unexpected end of rule")
+	}
+	token := p.tokens[p.pos]
+	return fmt.Errorf("unexpected %q at offset %d", token.text, token.offset)
+}
+
+func (p *ruleParser) or() (ruleExpr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = ruleOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) and() (ruleExpr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = ruleAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) not() (ruleExpr, error) {
+	if p.accept("NOT") {
+		expr, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return ruleNot{expr: expr}, nil
+	}
+	return p.primary()
+}
+
+func (p *ruleParser) primary() (ruleExpr, error) {
+	if p.accept("(") {
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.unexpected()
+		}
+		return expr, nil
+	}
+	if p.pos < len(p.tokens) && p.tokens[p.pos].quoted {
+		token := p.tokens[p.pos]
+		p.pos++
+		return ruleSubstring(token.text), nil
+	}
+	return nil, p.unexpected()
+}
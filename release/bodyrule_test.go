@@ -0,0 +1,54 @@
+package release
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBodyRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		body string
+		want bool
+	}{
+		{rule: `"Fix"`, body: "Fix zone crash", want: true},
+		{rule: `"Fix"`, body: "fix zone crash", want: false},
+		{rule: `"Fix" AND NOT "BREAKING"`, body: "Fix zone crash", want: true},
+		{rule: `"Fix" AND NOT "BREAKING"`, body: "Fix zone crash\nBREAKING: schema", want: false},
+		{rule: `"Fix" OR "Patch"`, body: "Patch login", want: true},
+		{rule: `"Fix" OR "Patch" AND "login"`, body: "Fix zone", want: true},
+		{rule: `("Fix" OR "Patch") AND "login"`, body: "Fix zone", want: false},
+		{rule: `NOT NOT 'zone'`, body: "Fix zone", want: true},
+		{rule: `"two words"`, body: "has two words", want: true},
+	}
+	for _, tt := range tests {
+		rule, err := ParseBodyRule(tt.rule)
+		if err != nil {
+			t.Fatalf("ParseBodyRule(%q) error = %v", tt.rule, err)
+		}
+		if got := rule.Match(tt.body); got != tt.want {
+			t.Errorf("%s.Match(%q) = %t, want %t", tt.rule, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParseBodyRuleErrors(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr string
+	}{
+		{rule: ``, wantErr: "unexpected end of rule"},
+		{rule: `Fix`, wantErr: `unexpected "Fix" at offset 0, substrings must be quoted`},
+		{rule: `"Fix" and "login"`, wantErr: `unexpected "and" at offset 6`},
+		{rule: `"Fix`, wantErr: "unterminated quote at offset 0"},
+		{rule: `("Fix"`, wantErr: "unexpected end of rule"},
+		{rule: `"Fix" "login"`, wantErr: `unexpected "login" at offset 6`},
+		{rule: `"Fix" OR )`, wantErr: `unexpected ")" at offset 9`},
+	}
+	for _, tt := range tests {
+		_, err := ParseBodyRule(tt.rule)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseBodyRule(%q) error = %v, want %q", tt.rule, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package release
+
+import (
+	"strings"
+)
+
+// ChangeCategory is the kind of change a changelog entry describes
+type ChangeCategory string
+
+const (
+	ChangeFixes    ChangeCategory = "fixes"
+	ChangeFeatures ChangeCategory = "features"
+	ChangeBreaking ChangeCategory = "breaking"
+	ChangeOther    ChangeCategory = "other"
+)
+
+// ChangeEntry is a single bullet of a release body
+type ChangeEntry struct {
+	Category ChangeCategory `json:"category"`
+	Text     string         `json:"text"`
+}
+
+// ParseChangelog splits a markdown release body into categorized bullet entries.
+// A bullet is categorized by its own prefix, e.g. "[Bug Fix] ..." or "Fix: ...",
+// falling back to the category of the heading it's under.
+func ParseChangelog(body string) []ChangeEntry {
+	entries := []ChangeEntry{}
+	section := ChangeOther
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			section = changeCategory(strings.TrimLeft(line, "# "))
+			continue
+		}
+
+		text, ok := bulletText(line)
+		if !ok || text == "" {
+			continue
+		}
+		category := changeCategory(bulletPrefix(text))
+		if category == ChangeOther {
+			category = section
+		}
+		entries = append(entries, ChangeEntry{Category: category, Text: text})
+	}
+	return entries
+}
+
+// CountChanges returns how many entries are in category
+func CountChanges(entries []ChangeEntry, category ChangeCategory) int {
+	count := 0
+	for _, entry := range entries {
+		if entry.Category == category {
+			count++
+		}
+	}
+	return count
+}
+
+// bulletText returns the text of a markdown bullet line
+func bulletText(line string) (string, bool) {
+	for _, prefix := range []string{"- ", "* ", "+ "} {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(line[len(prefix):]), true
+		}
+	}
+	return "", false
+}
+
+// bulletPrefix returns the leading tag of a bullet, e.g. "Bug Fix" for "[Bug Fix] Fix zoning"
+// or "Fix" for "Fix: zoning", or the first word otherwise
+func bulletPrefix(text string) string {
+	if strings.HasPrefix(text, "[") {
+		end := strings.Index(text, "]")
+		if end > 0 {
+			return text[1:end]
+		}
+	}
+	prefix, _, ok := strings.Cut(text, ":")
+	if ok && !strings.Contains(prefix, " ") {
+		return prefix
+	}
+	word, _, _ := strings.Cut(text, " ")
+	return word
+}
+
+// changeCategory guesses the category described by a heading or bullet prefix
+func changeCategory(label string) ChangeCategory {
+	label = strings.ToLower(label)
+	switch {
+	case strings.Contains(label, "breaking"):
+		return ChangeBreaking
+	case strings.Contains(label, "fix"):
+		return ChangeFixes
+	case strings.Contains(label, "feature"),
+		strings.HasPrefix(label, "feat"),
+		strings.HasPrefix(label, "add"),
+		strings.HasPrefix(label, "new"):
+		return ChangeFeatures
+	}
+	return ChangeOther
+}
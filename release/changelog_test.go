@@ -0,0 +1,37 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChangelog(t *testing.T) {
+	body := `## What's Changed
+
+* [Bug Fix] Fix zoning crash by @a in #1
+* [Feature] Add bazaar search by @b in #2
+- Fix: spell stacking
+- Tweak logging
+
+### Breaking Changes
+- Remove legacy login
+### Fixes
+- Correct faction hits
+`
+	want := []ChangeEntry{
+		{Category: ChangeFixes, Text: "[Bug Fix] Fix zoning crash by @a in #1"},
+		{Category: ChangeFeatures, Text: "[Feature] Add bazaar search by @b in #2"},
+		{Category: ChangeFixes, Text: "Fix: spell stacking"},
+		{Category: ChangeOther, Text: "Tweak logging"},
+		{Category: ChangeBreaking, Text: "Remove legacy login"},
+		{Category: ChangeFixes, Text: "Correct faction hits"},
+	}
+
+	got := ParseChangelog(body)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseChangelog() = %+v, want %+v", got, want)
+	}
+	if count := CountChanges(got, ChangeFixes); count != 3 {
+		t.Errorf("CountChanges(fixes) = %d, want 3", count)
+	}
+}
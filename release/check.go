@@ -0,0 +1,128 @@
+package release
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Gate is the outcome of one stable gate for a release
+type Gate struct {
+	// Name is tag, prerelease, semver, crashes, adoption or the name of a policy: age, notes,
+	// assets, fixes, body or the type of a custom policy
+	Name   string
+	Passed bool
+	// Detail explains the outcome, e.g. how old the release is
+	Detail string
+}
+
+// CheckRelease runs the stable gates that apply to a release on its own against rel, with
+// one gate per policy SelectReleases would apply. Gates comparing releases, like the minimum
+// gap or TrailCount, aren't run. The release qualifies as stable when every gate passed.
+func CheckRelease(rel *Release, opts Options) ([]Gate, error) {
+	gates := []Gate{}
+
+	if opts.TagPrefix != "" {
+		gates = append(gates, Gate{
+			Name:   "tag",
+			Passed: strings.HasPrefix(rel.TagName, opts.TagPrefix),
+			Detail: fmt.Sprintf("needs prefix %q", opts.TagPrefix),
+		})
+	}
+	gates = append(gates, Gate{
+		Name:   "prerelease",
+		Passed: !rel.Draft && !rel.Prerelease,
+		Detail: fmt.Sprintf("draft %t, prerelease %t", rel.Draft, rel.Prerelease),
+	})
+
+	semver := semverTag.MatchString(opts.versionTag(rel.TagName))
+	gates = append(gates, Gate{
+		Name:   "semver",
+		Passed: semver || opts.AllowNonSemver,
+		Detail: fmt.Sprintf("vMAJOR.MINOR.PATCH %t, non-semver allowed %t", semver, opts.AllowNonSemver),
+	})
+
+	// the gates are the policies SelectReleases applies, which need the publish date
+	policies := opts.policies()
+	if opts.TrailCount > 0 {
+		policies = opts.releaseChecks()
+	}
+	publishedAt, err := ParseTimestamp(rel.PublishedAt)
+	ctx := PolicyContext{Now: opts.CurrentTime(), PublishedAt: publishedAt, Logger: opts.logger()}
+	for _, policy := range policies {
+		if err != nil {
+			gates = append(gates, Gate{Name: policyName(policy), Detail: fmt.Sprintf("can't parse published at %q", rel.PublishedAt)})
+			continue
+		}
+		gates = append(gates, policyGate(policy, rel, ctx))
+	}
+
+	if opts.CrashCount == nil {
+		gates = append(gates, Gate{Name: "crashes", Passed: true, Detail: "not checked"})
+	} else {
+		count, err := opts.CrashCount(crashVersion(opts.versionTag(rel.TagName)))
+		if err != nil {
+			return nil, &StageError{Stage: ErrCrashFetch, Err: fmt.Errorf("errorCount: %w", err)}
+		}
+		gates = append(gates, Gate{
+			Name:   "crashes",
+			Passed: count <= opts.MaxCrashServers,
+			Detail: fmt.Sprintf("%d servers reported crashes, allows %d", count, opts.MaxCrashServers),
+		})
+	}
+
+	if opts.MinAdoption > 0 && opts.AdoptionCount != nil {
+		servers, err := opts.AdoptionCount(crashVersion(opts.versionTag(rel.TagName)))
+		if err != nil {
+			return nil, &StageError{Stage: ErrCrashFetch, Err: fmt.Errorf("adoptionCount: %w", err)}
+		}
+		gates = append(gates, Gate{
+			Name:   "adoption",
+			Passed: servers >= opts.MinAdoption,
+			Detail: fmt.Sprintf("%d servers running, needs %d", servers, opts.MinAdoption),
+		})
+	}
+	return gates, nil
+}
+
+// policyName returns the gate name of policy, its type for a custom policy
+func policyName(policy SelectionPolicy) string {
+	switch policy.(type) {
+	case MinAgePolicy:
+		return "age"
+	case KeywordPolicy:
+		return "fixes"
+	case BodyRulePolicy:
+		return "body"
+	case MinBodyLengthPolicy:
+		return "notes"
+	case RequireAssetPolicy:
+		return "assets"
+	}
+	return fmt.Sprintf("%T", policy)
+}
+
+// policyGate runs policy against rel, explaining the outcome of a built-in policy and giving
+// the reason a custom policy rejected rel
+func policyGate(policy SelectionPolicy, rel *Release, ctx PolicyContext) Gate {
+	passed, reason := policy.Eligible(rel, ctx)
+	gate := Gate{Name: policyName(policy), Passed: passed, Detail: string(reason)}
+	switch p := policy.(type) {
+	case MinAgePolicy:
+		age := ctx.Now.Sub(ctx.PublishedAt).Round(time.Minute)
+		gate.Detail = fmt.Sprintf("published %s ago, needs %s", age, p.MinAge)
+	case KeywordPolicy:
+		keywords := p.Keywords
+		if len(keywords) == 0 {
+			keywords = []string{"fix"}
+		}
+		gate.Detail = fmt.Sprintf("%d lines containing %s, needs %d", countKeywordLines(rel.Body, keywords), strings.Join(keywords, ","), p.MinFixes)
+	case BodyRulePolicy:
+		gate.Detail = fmt.Sprintf("needs %s", p.Rule.String())
+	case MinBodyLengthPolicy:
+		gate.Detail = fmt.Sprintf("%d characters, needs %d", bodyLength(rel.Body), p.MinLength)
+	case RequireAssetPolicy:
+		gate.Detail = fmt.Sprintf("missing %d of %s", len(missingAssets(rel, p.Patterns)), strings.Join(p.Patterns, ","))
+	}
+	return gate
+}
@@ -0,0 +1,73 @@
+package release
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRelease(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CrashCount = func(version string) (int, error) {
+		if version != "1.9.0" {
+			t.Errorf("CrashCount(%q), want 1.9.0", version)
+		}
+		return 2, nil
+	}
+
+	gates, err := CheckRelease(testRelease("v1.9.0", 1*day, "Fix login"), opts)
+	if err != nil {
+		t.Fatalf("CheckRelease() error = %v", err)
+	}
+	want := map[string]bool{
+		"prerelease": true,
+		"semver":     true,
+		"age":        false,
+		"fixes":      true,
+		"crashes":    false,
+	}
+	if len(gates) != len(want) {
+		t.Fatalf("CheckRelease() = %d gates, want %d", len(gates), len(want))
+	}
+	for _, gate := range gates {
+		if gate.Passed != want[gate.Name] {
+			t.Errorf("gate %s passed = %t, want %t (%s)", gate.Name, gate.Passed, want[gate.Name], gate.Detail)
+		}
+	}
+}
+
+func TestCheckReleasePolicies(t *testing.T) {
+	rule, err := ParseBodyRule(`"Fix"`)
+	if err != nil {
+		t.Fatalf("ParseBodyRule() error = %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Policies = []SelectionPolicy{MinAgePolicy{MinAge: opts.MinAge}, BodyRulePolicy{Rule: rule}}
+	opts.MinBodyLength = 5
+	rel := testRelease("v1.9.0", 20*day, "New zone, BREAKING")
+
+	gates, err := CheckRelease(rel, opts)
+	if err != nil {
+		t.Fatalf("CheckRelease() error = %v", err)
+	}
+	// the gates follow the policies, so there's no fixes gate and body fails like it does in selection
+	want := []Gate{
+		{Name: "prerelease", Passed: true},
+		{Name: "semver", Passed: true},
+		{Name: "notes", Passed: true},
+		{Name: "age", Passed: true},
+		{Name: "body", Passed: false},
+		{Name: "crashes", Passed: true},
+	}
+	if len(gates) != len(want) {
+		t.Fatalf("CheckRelease() = %+v, want %d gates", gates, len(want))
+	}
+	for i, gate := range gates {
+		if gate.Name != want[i].Name || gate.Passed != want[i].Passed {
+			t.Errorf("gate %d = %s passed %t, want %s passed %t", i, gate.Name, gate.Passed, want[i].Name, want[i].Passed)
+		}
+	}
+	_, _, _, err = SelectReleases([]*Release{rel}, opts)
+	if !errors.Is(err, ErrNoRelease) {
+		t.Errorf("SelectReleases() error = %v, want ErrNoRelease", err)
+	}
+}
@@ -0,0 +1,30 @@
+package release
+
+import "errors"
+
+// failure stages errors are classified by with errors.Is, alongside ErrNoRelease. SelectReleases
+// and CheckRelease return ErrCrashFetch when Options.CrashCount or AdoptionCount fails, the
+// command line tool also returns the others.
+var (
+	// ErrGitHubFetch is a failure fetching releases from GitHub
+	ErrGitHubFetch = errors.New("github fetch failed")
+	// ErrCrashFetch is a failure fetching crash reports from Spire, or adoption counts
+	ErrCrashFetch = errors.New("crash report fetch failed")
+	// ErrWriteOutput is a failure writing to the out dir
+	ErrWriteOutput = errors.New("write output failed")
+)
+
+// StageError tags Err with the stage it failed in, one of the failure stages, keeping
+// Err's message as is
+type StageError struct {
+	Stage error
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() []error {
+	return []error{e.Stage, e.Err}
+}
@@ -0,0 +1,150 @@
+package release
+
+import (
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PolicyContext is what a SelectionPolicy knows about the release being checked
+type PolicyContext struct {
+	// Now is the time release ages are measured from
+	Now time.Time
+	// PublishedAt is the release's parsed publish date
+	PublishedAt time.Time
+	// Logger receives any debug records the policy writes
+	Logger *slog.Logger
+}
+
+// SelectionPolicy is a gate a release must pass to be a stable candidate. Policies run on
+// every release in publish order, so they should be cheap; the crash gate runs afterwards
+// on the candidates since it makes a request per release.
+type SelectionPolicy interface {
+	// Eligible reports whether rel passes, and if not the reason it's skipped with
+	Eligible(rel *Release, ctx PolicyContext) (bool, Reason)
+}
+
+// MinAgePolicy rejects releases published less than MinAge ago
+type MinAgePolicy struct {
+	MinAge time.Duration
+}
+
+func (p MinAgePolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	if ctx.Now.Sub(ctx.PublishedAt) < p.MinAge {
+		ctx.Logger.Debug("release too new", "tag", rel.TagName, "min_age", p.MinAge)
+		return false, ReasonTooNew
+	}
+	return true, ""
+}
+
+// KeywordPolicy rejects releases with fewer than MinFixes body lines containing one of
+// Keywords, which default to "fix"
+type KeywordPolicy struct {
+	Keywords []string
+	MinFixes int
+}
+
+func (p KeywordPolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	keywords := p.Keywords
+	if len(keywords) == 0 {
+		keywords = []string{"fix"}
+	}
+	fixes := countKeywordLines(rel.Body, keywords)
+	ctx.Logger.Debug("counted fixes", "tag", rel.TagName, "fixes", fixes, "min_fixes", p.MinFixes, "keywords", strings.Join(keywords, ","))
+	if fixes < p.MinFixes {
+		return false, ReasonNoFix
+	}
+	return true, ""
+}
+
+// BodyRulePolicy rejects releases whose body doesn't match Rule
+type BodyRulePolicy struct {
+	Rule *BodyRule
+}
+
+func (p BodyRulePolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	if !p.Rule.Match(rel.Body) {
+		ctx.Logger.Debug("body doesn't match rule", "tag", rel.TagName, "rule", p.Rule.String())
+		return false, ReasonBodyRule
+	}
+	return true, ""
+}
+
+// MinBodyLengthPolicy rejects releases whose trimmed body is shorter than MinLength characters,
+// such as an empty body or a "." placeholder
+type MinBodyLengthPolicy struct {
+	MinLength int
+}
+
+func (p MinBodyLengthPolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	length := bodyLength(rel.Body)
+	if length < p.MinLength {
+		ctx.Logger.Debug("release notes too short", "tag", rel.TagName, "length", length, "min_body_length", p.MinLength)
+		return false, ReasonEmptyNotes
+	}
+	return true, ""
+}
+
+// bodyLength is how many characters body has, ignoring surrounding whitespace
+func bodyLength(body string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(body))
+}
+
+// RequireAssetPolicy rejects releases without an asset matching each of Patterns, globs
+// matched against asset names with path.Match
+type RequireAssetPolicy struct {
+	Patterns []string
+}
+
+func (p RequireAssetPolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	missing := missingAssets(rel, p.Patterns)
+	if len(missing) > 0 {
+		ctx.Logger.Debug("release missing required asset", "tag", rel.TagName, "missing", strings.Join(missing, ","), "patterns", strings.Join(p.Patterns, ","))
+		return false, ReasonMissingAsset
+	}
+	return true, ""
+}
+
+// missingAssets returns the patterns no asset of rel matches, a malformed pattern matches nothing
+func missingAssets(rel *Release, patterns []string) []string {
+	missing := []string{}
+	for _, pattern := range patterns {
+		found := false
+		for _, asset := range rel.Assets {
+			ok, _ := path.Match(pattern, asset.Name)
+			found = found || ok
+		}
+		if !found {
+			missing = append(missing, pattern)
+		}
+	}
+	return missing
+}
+
+// policies returns Policies, or the age and keyword policies built from the options if unset,
+// preceded by the releaseChecks
+func (o *Options) policies() []SelectionPolicy {
+	policies := o.releaseChecks()
+	if o.Policies != nil {
+		return append(policies, o.Policies...)
+	}
+	return append(policies,
+		MinAgePolicy{MinAge: o.MinAge},
+		KeywordPolicy{Keywords: o.Keywords, MinFixes: o.MinFixes},
+	)
+}
+
+// releaseChecks returns a MinBodyLengthPolicy and RequireAssetPolicy when MinBodyLength and
+// RequireAssets are set. They apply whatever Policies are used, and with TrailCount too.
+func (o *Options) releaseChecks() []SelectionPolicy {
+	policies := []SelectionPolicy{}
+	if o.MinBodyLength > 0 {
+		policies = append(policies, MinBodyLengthPolicy{MinLength: o.MinBodyLength})
+	}
+	if len(o.RequireAssets) > 0 {
+		policies = append(policies, RequireAssetPolicy{Patterns: o.RequireAssets})
+	}
+	return policies
+}
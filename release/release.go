@@ -0,0 +1,50 @@
+// Package release selects the latest and stable EQEmu server releases from a list of GitHub releases.
+package release
+
+import "time"
+
+// Release is a GitHub release, decoded from the releases API
+type Release struct {
+	Name        string  `json:"name"`
+	TagName     string  `json:"tag_name"`
+	PublishedAt string  `json:"published_at"`
+	Prerelease  bool    `json:"prerelease"`
+	Draft       bool    `json:"draft"`
+	Body        string  `json:"body"`
+	Assets      []Asset `json:"assets"`
+	// TarballURL and ZipballURL are the source archives GitHub generates for every release
+	TarballURL string `json:"tarball_url"`
+	ZipballURL string `json:"zipball_url"`
+}
+
+// Asset is a file uploaded to a release
+type Asset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// timestampLayouts are the layouts ParseTimestamp accepts, GitHub's RFC 3339 first.
+// Layouts without a zone are read as UTC.
+var timestampLayouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// ParseTimestamp parses a GitHub timestamp such as PublishedAt, accepting minor variations of
+// RFC 3339 in case the API format changes. The error is the one for RFC 3339 if none match.
+func ParseTimestamp(value string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
@@ -0,0 +1,34 @@
+package release
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2023, 9, 18, 17, 19, 56, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2023-09-18T17:19:56Z", want: want},
+		{value: "2023-09-18T19:19:56+02:00", want: want},
+		{value: "2023-09-18T17:19:56.123456789Z", want: want.Add(123456789 * time.Nanosecond)},
+		{value: "2023-09-18 17:19:56Z", want: want},
+		{value: "2023-09-18T17:19:56", want: want},
+		{value: "2023-09-18 17:19:56", want: want},
+		{value: "", wantErr: true},
+		{value: "18 Sep 2023", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimestamp(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimestamp(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package release
+
+import (
+	"math"
+	"time"
+)
+
+// SelectionMode controls how SelectReleases picks stable among the candidates passing every gate
+type SelectionMode int
+
+const (
+	// SelectFirst picks the highest version candidate passing every gate
+	SelectFirst SelectionMode = iota
+	// SelectScore crash checks every candidate and picks the one with the highest Weights score,
+	// the higher version winning a tie
+	SelectScore
+)
+
+// ScoreWeights weighs what SelectScore ranks candidates by
+type ScoreWeights struct {
+	// Recency is the score of a release published now, halving every RecencyHalfLife of age
+	Recency         float64
+	RecencyHalfLife time.Duration
+	// Crashes is subtracted for each distinct server that reported crashes
+	Crashes float64
+	// Fixes is added for each body line containing one of Keywords
+	Fixes float64
+}
+
+// DefaultScoreWeights returns the weights used by the command line tool by default
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{
+		Recency:         10,
+		RecencyHalfLife: 14 * 24 * time.Hour,
+		Crashes:         5,
+		Fixes:           1,
+	}
+}
+
+// score returns the Weights score of rel, published at publishedAt with crashes distinct
+// servers reporting crashes
+func (o *Options) score(rel *Release, publishedAt time.Time, crashes int) float64 {
+	keywords := o.Keywords
+	if len(keywords) == 0 {
+		keywords = []string{"fix"}
+	}
+	w := o.Weights
+	score := w.Fixes*float64(countKeywordLines(rel.Body, keywords)) - w.Crashes*float64(crashes)
+	if w.RecencyHalfLife > 0 {
+		age := max(o.CurrentTime().Sub(publishedAt), 0)
+		score += w.Recency * math.Exp2(-float64(age)/float64(w.RecencyHalfLife))
+	}
+	return score
+}
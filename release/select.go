@@ -0,0 +1,665 @@
+package release
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoRelease is returned by SelectReleases when no release qualifies as stable and there is no fallback
+var ErrNoRelease = errors.New("no releases found")
+
+// Reason is why a release was skipped or selected
+type Reason string
+
+const (
+	ReasonDraft      Reason = "DRAFT"
+	ReasonPrerelease Reason = "PRERELEASE"
+	// ReasonNotPrerelease is a full release when only prereleases are considered
+	ReasonNotPrerelease Reason = "NOT_PRERELEASE"
+	ReasonNotSemver     Reason = "NOT_SEMVER"
+	// ReasonTagFiltered is a release whose tag doesn't start with Options.TagPrefix
+	ReasonTagFiltered Reason = "TAG_FILTERED"
+	// ReasonUnpublished is a release with a missing or unparseable publish date
+	ReasonUnpublished Reason = "UNPUBLISHED"
+	// ReasonBeforeSince is a release published before Options.Since
+	ReasonBeforeSince Reason = "BEFORE_SINCE"
+	ReasonTooClose    Reason = "TOO_CLOSE"
+	ReasonTooNew      Reason = "TOO_NEW"
+	// ReasonTrailing is a release within TrailCount releases of the latest
+	ReasonTrailing Reason = "TRAILING"
+	ReasonNoFix    Reason = "NO_FIX"
+	// ReasonEmptyNotes is a release whose body is shorter than Options.MinBodyLength
+	ReasonEmptyNotes Reason = "EMPTY_NOTES"
+	// ReasonMissingAsset is a release without an asset matching one of Options.RequireAssets
+	ReasonMissingAsset Reason = "MISSING_ASSET"
+	// ReasonBodyRule is a release whose body doesn't match a BodyRulePolicy
+	ReasonBodyRule   Reason = "BODY_RULE"
+	ReasonHasCrashes Reason = "HAS_CRASHES"
+	// ReasonCrashUnknown is a release whose crash count couldn't be fetched with CrashSoftFail
+	ReasonCrashUnknown Reason = "CRASH_UNKNOWN"
+	// ReasonLowAdoption is a release fewer than Options.MinAdoption servers are running
+	ReasonLowAdoption Reason = "LOW_ADOPTION"
+	// ReasonNotNewer is a candidate at or below Options.PromoteFrom
+	ReasonNotNewer Reason = "NOT_NEWER"
+	// ReasonCurrent is the PromoteFrom release kept as stable when no newer release qualified
+	ReasonCurrent Reason = "CURRENT"
+	// ReasonOutscored is a candidate passing every gate with a lower score than the selected
+	// release, with Options.SelectionMode SelectScore
+	ReasonOutscored Reason = "OUTSCORED"
+	ReasonSelected  Reason = "SELECTED"
+	// ReasonCandidate is a release passing every gate selected after stable with StableCount
+	ReasonCandidate Reason = "CANDIDATE"
+	ReasonFallback  Reason = "FALLBACK"
+)
+
+// Decision records why a release was skipped or selected
+type Decision struct {
+	Release *Release
+	Reason  Reason
+	// LastPublishedAt is when the previous release was published, set for ReasonTooClose
+	LastPublishedAt time.Time
+	// ErrorCount is the crash count observed for the release, nil if it wasn't checked
+	ErrorCount *int
+}
+
+// PrereleaseMode controls which releases SelectReleases considers
+type PrereleaseMode int
+
+const (
+	// PrereleasesSkip considers only full releases
+	PrereleasesSkip PrereleaseMode = iota
+	// PrereleasesOnly considers only prereleases
+	PrereleasesOnly
+	// PrereleasesInclude considers prereleases alongside full releases
+	PrereleasesInclude
+)
+
+// GapBehavior controls what SelectReleases does with releases published within MinGap of each other
+type GapBehavior int
+
+const (
+	// GapSkip considers only the newest release of a cluster published within MinGap of each other
+	GapSkip GapBehavior = iota
+	// GapCollapse treats such a cluster as one release, considering only its highest version
+	// passing the policies
+	GapCollapse
+)
+
+// Options configures SelectReleases
+type Options struct {
+	// MinAge is how old a release must be before it can be stable
+	MinAge time.Duration
+	// FallbackAge is how old a release must be to be used as a fallback when nothing qualifies
+	FallbackAge time.Duration
+	// MinGap is how far apart releases must be published to be considered
+	MinGap time.Duration
+	// GapBehavior is how releases published within MinGap of each other are handled
+	GapBehavior GapBehavior
+	// Keywords are matched case-insensitively against each line of a release body,
+	// defaulting to "fix". An empty keyword matches every line.
+	Keywords []string
+	// MinFixes is how many lines of a release body must contain a keyword for it to be stable
+	MinFixes int
+	// MinBodyLength is how many characters a release body must have, ignoring surrounding
+	// whitespace, for it to be stable. It's checked before Policies, 0 disables it.
+	MinBodyLength int
+	// RequireAssets are globs a stable release must have a matching asset for each of, such as
+	// the server binary, so a botched upload isn't promoted. They're checked before Policies.
+	RequireAssets []string
+	// MaxCrashServers is how many distinct servers may report crashes before a release is rejected
+	MaxCrashServers int
+	// AllowNonSemver allows tags that don't look like vMAJOR.MINOR.PATCH
+	AllowNonSemver bool
+	// TagPrefix skips releases whose tag doesn't start with it, e.g. "server-" when a repo
+	// also tags tools releases. It's stripped before a tag is parsed as a version.
+	TagPrefix string
+	// Since discards releases published before it, ignored if zero
+	Since time.Time
+	// Prereleases controls whether prereleases are considered, by default they're skipped
+	Prereleases PrereleaseMode
+	// CrashCount returns how many distinct servers reported crashes running version,
+	// the tag without a leading "v". Nil skips the crash check.
+	CrashCount func(version string) (int, error)
+	// MinAdoption is how many distinct servers must be running a release before it can be
+	// stable, so one nobody runs yet isn't mistaken for one without crashes. It's checked after
+	// the crash gate and ignored without AdoptionCount.
+	MinAdoption int
+	// AdoptionCount returns how many distinct servers are running version, the tag without a
+	// leading "v"
+	AdoptionCount func(version string) (int, error)
+	// Policies are the gates a release must pass to be a stable candidate, in order.
+	// Nil uses a MinAgePolicy and KeywordPolicy built from MinAge, Keywords and MinFixes.
+	Policies []SelectionPolicy
+	// TrailCount selects stable as the release this many versions behind the latest instead
+	// of using the gap gate and policies. MinBodyLength, RequireAssets and the crash gate still
+	// apply, moving further back past releases failing them, and the fallback is used when none
+	// pass. 0 uses the gates.
+	TrailCount int
+	// PromoteFrom is the tag of the current stable release. When set, stable only advances one
+	// step: the lowest version candidate above it passing the crash gate is selected, and the
+	// current release is kept if none does. Ignored if no release with a version is tagged PromoteFrom.
+	PromoteFrom string
+	// MaxCandidates is how many candidates passing the cheap gates are crash checked before
+	// giving up and using the fallback, 0 means no limit
+	MaxCandidates int
+	// SelectionMode is how stable is picked among the candidates passing every gate
+	SelectionMode SelectionMode
+	// StableCount is how many candidates passing every gate are selected, in order of preference,
+	// for canary rollouts. The first is stable and the rest are decided as ReasonCandidate.
+	// 0 or 1 selects only stable.
+	StableCount int
+	// Weights score candidates with SelectScore
+	Weights ScoreWeights
+	// CrashSoftFail skips a candidate whose crash count can't be fetched instead of failing
+	CrashSoftFail bool
+	// CrashPrefetch is how many of the top candidates have their crash counts fetched
+	// concurrently before they're evaluated in order. 0 or 1 fetches them one at a time.
+	CrashPrefetch int
+	// OnDecision is called for each release skipped or selected, if set
+	OnDecision func(Decision)
+	// Logger receives a debug record for each decision, discarded if nil
+	Logger *slog.Logger
+	// Now returns the time release ages are measured from, time.Now if nil
+	Now func() time.Time
+}
+
+// DefaultOptions returns the options used by the command line tool by default
+func DefaultOptions() Options {
+	return Options{
+		MinAge:      7 * 24 * time.Hour,
+		FallbackAge: 30 * 24 * time.Hour,
+		MinGap:      3 * 24 * time.Hour,
+		Keywords:    []string{"fix"},
+		MinFixes:    1,
+		Weights:     DefaultScoreWeights(),
+	}
+}
+
+func (o *Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return o.Logger
+}
+
+// CurrentTime returns the time release ages are measured from, Now or time.Now if it isn't set
+func (o *Options) CurrentTime() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// versionTag returns tag without TagPrefix, the part parsed as a version
+func (o *Options) versionTag(tag string) string {
+	return strings.TrimPrefix(tag, o.TagPrefix)
+}
+
+// decide logs msg with the tag, reason and publish date of decision and reports it to OnDecision
+func (o *Options) decide(decision Decision, msg string, args ...any) {
+	attrs := []any{
+		"tag", decision.Release.TagName,
+		"reason", decision.Reason,
+		"published_at", decision.Release.PublishedAt,
+	}
+	o.logger().Debug(msg, append(attrs, args...)...)
+	if o.OnDecision != nil {
+		o.OnDecision(decision)
+	}
+}
+
+// SelectReleases picks the highest versioned release as latest and the highest versioned
+// release qualifying as stable. The gap and age gates consider releases newest first by
+// publish date, the crash check then runs highest version first until one passes.
+// When nothing qualifies the newest release older than FallbackAge is used as stable and
+// usedFallback is set, if there is none ErrNoRelease is returned.
+func SelectReleases(releases []*Release, opts Options) (stable, unstable *Release, usedFallback bool, err error) {
+	policies := opts.policies()
+	releases = append([]*Release{}, releases...)
+	logger := opts.logger()
+	now := opts.CurrentTime()
+	sortReleases(releases, logger)
+
+	var latestStableRelease *Release
+	var fallbackRelease *Release
+	// previousPublishedAt is when the release preceding the current one in sorted order was published
+	var previousPublishedAt time.Time
+	// published are the releases eligible as latest, newest first
+	published := []*Release{}
+	// candidates passed the cheap gates and only need the crash check to be stable
+	candidates := []*Release{}
+	// clusters maps each candidate to the cluster of releases within MinGap of each other it was
+	// published in, with GapCollapse. Every member stays a candidate until one passes the crash gate.
+	clusters := map[*Release]int{}
+	cluster := 0
+
+	for _, release := range releases {
+		if !strings.HasPrefix(release.TagName, opts.TagPrefix) {
+			opts.decide(Decision{Release: release, Reason: ReasonTagFiltered}, "skipping tag without prefix", "tag_prefix", opts.TagPrefix)
+			continue
+		}
+		if release.Draft {
+			opts.decide(Decision{Release: release, Reason: ReasonDraft}, "skipping draft")
+			continue
+		}
+		if release.Prerelease && opts.Prereleases == PrereleasesSkip {
+			opts.decide(Decision{Release: release, Reason: ReasonPrerelease}, "skipping prerelease")
+			continue
+		}
+		if !release.Prerelease && opts.Prereleases == PrereleasesOnly {
+			opts.decide(Decision{Release: release, Reason: ReasonNotPrerelease}, "skipping release, only prereleases are considered")
+			continue
+		}
+		if !opts.AllowNonSemver && !semverTag.MatchString(opts.versionTag(release.TagName)) {
+			opts.decide(Decision{Release: release, Reason: ReasonNotSemver}, "skipping tag that isn't vMAJOR.MINOR.PATCH")
+			continue
+		}
+		// convert PublishedAt 2023-09-18T17:19:56Z to time.Time
+		publishedAt, err := ParseTimestamp(release.PublishedAt)
+		if err != nil {
+			// a date in an unexpected layout is worth noticing, only this release is skipped
+			if release.PublishedAt != "" {
+				logger.Warn("skipping release with an unparseable publish date", "tag", release.TagName, "published_at", release.PublishedAt)
+			}
+			opts.decide(Decision{Release: release, Reason: ReasonUnpublished}, "skipping release without a valid publish date", "err", err)
+			continue
+		}
+		if publishedAt.Before(opts.Since) {
+			opts.decide(Decision{Release: release, Reason: ReasonBeforeSince}, "skipping release published before since", "since", opts.Since)
+			continue
+		}
+		published = append(published, release)
+
+		// a release is too close when it was published less than MinGap from the one preceding it
+		previous := previousPublishedAt
+		previousPublishedAt = publishedAt
+		tooClose := opts.TrailCount == 0 && !previous.IsZero() && absDuration(previous.Sub(publishedAt)) < opts.MinGap
+		if tooClose && opts.GapBehavior == GapSkip {
+			opts.decide(Decision{Release: release, Reason: ReasonTooClose, LastPublishedAt: previous},
+				"skipping release too close to previous release", "last_published_at", previous)
+			continue
+		}
+		if !tooClose {
+			cluster++
+		}
+
+		if fallbackRelease == nil &&
+			now.Sub(publishedAt) > opts.FallbackAge {
+			fallbackRelease = release
+			logger.Debug("setting fallback release", "tag", release.TagName, "fallback_age", opts.FallbackAge)
+		}
+		logger.Debug("checking release", "tag", release.TagName)
+		// trailing replaces the gap gate and policies, its candidates are picked after the loop
+		if opts.TrailCount > 0 {
+			continue
+		}
+
+		if !opts.eligible(release, policies, PolicyContext{Now: now, PublishedAt: publishedAt, Logger: logger}) {
+			continue
+		}
+		if opts.GapBehavior == GapCollapse {
+			clusters[release] = cluster
+		}
+		candidates = append(candidates, release)
+	}
+
+	if len(published) == 0 {
+		return nil, nil, false, ErrNoRelease
+	}
+	latestUnstableRelease := highestVersion(published, opts.TagPrefix)
+	if latestUnstableRelease != published[0] {
+		logger.Warn("newest release isn't the highest version, using the highest version as latest",
+			"newest", published[0].TagName, "highest", latestUnstableRelease.TagName)
+	}
+
+	if opts.TrailCount > 0 {
+		candidates = opts.trailingCandidates(published, now)
+	}
+
+	sortByVersion(candidates, opts.TagPrefix)
+	current := opts.currentStable(releases)
+	if current != nil {
+		candidates = opts.promotionCandidates(candidates, current)
+	}
+	inspected := candidates
+	if opts.MaxCandidates > 0 && len(inspected) > opts.MaxCandidates {
+		inspected = inspected[:opts.MaxCandidates]
+	}
+	prefetched := opts.prefetchCrashCounts(inspected)
+	// scored are the candidates passing every gate with SelectScore
+	scored := []scoredRelease{}
+	selected := 0
+	// collapsed are candidates dropped for a higher version in their cluster passing every gate
+	collapsed := map[*Release]bool{}
+	for i, release := range inspected {
+		if selected >= opts.stableCount() {
+			break
+		}
+		if collapsed[release] {
+			continue
+		}
+		var errorCount *int
+		if opts.CrashCount != nil {
+			var count int
+			var err error
+			if i < len(prefetched) {
+				count, err = prefetched[i].count, prefetched[i].err
+			} else {
+				count, err = opts.CrashCount(crashVersion(opts.versionTag(release.TagName)))
+			}
+			if err != nil && opts.CrashSoftFail {
+				opts.decide(Decision{Release: release, Reason: ReasonCrashUnknown}, "skipping release, crash count unknown", "err", err)
+				continue
+			}
+			if err != nil {
+				return nil, nil, false, &StageError{Stage: ErrCrashFetch, Err: fmt.Errorf("errorCount: %w", err)}
+			}
+			errorCount = &count
+
+			if count > opts.MaxCrashServers {
+				opts.decide(Decision{Release: release, Reason: ReasonHasCrashes, ErrorCount: errorCount}, "skipping release with crashes",
+					"errors", count, "max_crash_servers", opts.MaxCrashServers)
+				continue
+			}
+			logger.Debug("counted crashes", "tag", release.TagName, "errors", count, "max_crash_servers", opts.MaxCrashServers)
+		}
+		if opts.MinAdoption > 0 && opts.AdoptionCount != nil {
+			servers, err := opts.AdoptionCount(crashVersion(opts.versionTag(release.TagName)))
+			if err != nil {
+				return nil, nil, false, &StageError{Stage: ErrCrashFetch, Err: fmt.Errorf("adoptionCount: %w", err)}
+			}
+			if servers < opts.MinAdoption {
+				opts.decide(Decision{Release: release, Reason: ReasonLowAdoption, ErrorCount: errorCount}, "skipping release running on too few servers",
+					"servers", servers, "min_adoption", opts.MinAdoption)
+				continue
+			}
+			logger.Debug("counted servers running release", "tag", release.TagName, "servers", servers, "min_adoption", opts.MinAdoption)
+		}
+
+		// candidates are highest version first, so the rest of its cluster is lower
+		opts.collapseCluster(release, candidates[i+1:], clusters, collapsed)
+		if opts.SelectionMode == SelectScore {
+			scored = append(scored, opts.scoreRelease(release, errorCount))
+			continue
+		}
+		selected++
+		if latestStableRelease != nil {
+			opts.decide(Decision{Release: release, Reason: ReasonCandidate, ErrorCount: errorCount}, "selected stable candidate")
+			continue
+		}
+		latestStableRelease = release
+		opts.decide(Decision{Release: release, Reason: ReasonSelected, ErrorCount: errorCount}, "selected stable release")
+	}
+	if len(scored) > 0 {
+		latestStableRelease = opts.selectScored(scored)
+	}
+
+	if latestStableRelease == nil && len(inspected) < len(candidates) {
+		logger.Warn("no candidate qualified within the candidate limit, not inspecting the rest",
+			"max_candidates", opts.MaxCandidates, "remaining", len(candidates)-len(inspected))
+	}
+	if latestStableRelease == nil && current != nil {
+		latestStableRelease = current
+		opts.decide(Decision{Release: current, Reason: ReasonCurrent}, "no newer release qualified, keeping current stable")
+	}
+	if latestStableRelease == nil {
+		if fallbackRelease == nil {
+			return nil, nil, false, ErrNoRelease
+		}
+		latestStableRelease = fallbackRelease
+		usedFallback = true
+		opts.decide(Decision{Release: fallbackRelease, Reason: ReasonFallback}, "no releases qualified, using fallback release")
+	}
+
+	return latestStableRelease, latestUnstableRelease, usedFallback, nil
+}
+
+// collapseCluster decides the members of kept's cluster among rest as collapsed into it and
+// adds them to collapsed, if kept is in a cluster
+func (o *Options) collapseCluster(kept *Release, rest []*Release, clusters map[*Release]int, collapsed map[*Release]bool) {
+	cluster, ok := clusters[kept]
+	if !ok {
+		return
+	}
+	// candidates passed the publish date gate, so it parses
+	keptPublishedAt, _ := ParseTimestamp(kept.PublishedAt)
+	for _, release := range rest {
+		if member, ok := clusters[release]; !ok || member != cluster || collapsed[release] {
+			continue
+		}
+		collapsed[release] = true
+		o.decide(Decision{Release: release, Reason: ReasonTooClose, LastPublishedAt: keptPublishedAt},
+			"skipping release collapsed into a higher version published close to it", "kept", kept.TagName)
+	}
+}
+
+// scoredRelease is a candidate passing every gate and its score
+type scoredRelease struct {
+	release    *Release
+	errorCount *int
+	score      float64
+}
+
+// scoreRelease scores a candidate that passed every gate, with no crashes if they weren't counted
+func (o *Options) scoreRelease(release *Release, errorCount *int) scoredRelease {
+	crashes := 0
+	if errorCount != nil {
+		crashes = *errorCount
+	}
+	// candidates passed the publish date gate, so it parses
+	publishedAt, _ := ParseTimestamp(release.PublishedAt)
+	return scoredRelease{release: release, errorCount: errorCount, score: o.score(release, publishedAt, crashes)}
+}
+
+// selectScored decides the highest scoring release, which comes first on a tie since scored
+// is highest version first, the next StableCount-1 as candidates and the rest as outscored
+func (o *Options) selectScored(scored []scoredRelease) *Release {
+	ranked := append([]scoredRelease{}, scored...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].score > ranked[j].score
+	})
+	count := min(o.stableCount(), len(ranked))
+	best := ranked[0]
+	o.decide(Decision{Release: best.release, Reason: ReasonSelected, ErrorCount: best.errorCount}, "selected stable release",
+		"score", best.score)
+	for _, s := range ranked[1:count] {
+		o.decide(Decision{Release: s.release, Reason: ReasonCandidate, ErrorCount: s.errorCount}, "selected stable candidate",
+			"score", s.score)
+	}
+	for _, s := range ranked[count:] {
+		o.decide(Decision{Release: s.release, Reason: ReasonOutscored, ErrorCount: s.errorCount}, "skipping release outscored by another candidate",
+			"score", s.score, "best", best.release.TagName, "best_score", best.score)
+	}
+	return best.release
+}
+
+// stableCount returns how many candidates are selected, at least one
+func (o *Options) stableCount() int {
+	return max(o.StableCount, 1)
+}
+
+// currentStable returns the release tagged PromoteFrom, or nil if it isn't set or there is
+// no such release with a version
+func (o *Options) currentStable(releases []*Release) *Release {
+	if o.PromoteFrom == "" {
+		return nil
+	}
+	for _, release := range releases {
+		if release.TagName != o.PromoteFrom {
+			continue
+		}
+		if _, ok := parseVersion(o.versionTag(release.TagName)); ok {
+			return release
+		}
+	}
+	o.logger().Warn("current stable release not found, selecting from every release", "promote_from", o.PromoteFrom)
+	return nil
+}
+
+// promotionCandidates returns the candidates with a higher version than current, lowest first
+func (o *Options) promotionCandidates(candidates []*Release, current *Release) []*Release {
+	currentVersion, _ := parseVersion(o.versionTag(current.TagName))
+	newer := []*Release{}
+	for _, release := range candidates {
+		v, ok := parseVersion(o.versionTag(release.TagName))
+		if !ok || v.compare(currentVersion) <= 0 {
+			o.decide(Decision{Release: release, Reason: ReasonNotNewer}, "skipping release not newer than current stable", "current", current.TagName)
+			continue
+		}
+		newer = append(newer, release)
+	}
+	slices.Reverse(newer)
+	return newer
+}
+
+// eligible reports whether release passes every one of policies, deciding it with the
+// reason of the first that rejects it
+func (o *Options) eligible(release *Release, policies []SelectionPolicy, ctx PolicyContext) bool {
+	for _, policy := range policies {
+		ok, reason := policy.Eligible(release, ctx)
+		if !ok {
+			o.decide(Decision{Release: release, Reason: reason}, "skipping release rejected by policy", "policy", fmt.Sprintf("%T", policy))
+			return false
+		}
+	}
+	return true
+}
+
+// trailingCandidates returns published releases more than TrailCount versions behind the latest
+// passing the releaseChecks. Releases failing them still count towards TrailCount.
+func (o *Options) trailingCandidates(published []*Release, now time.Time) []*Release {
+	trailed := append([]*Release{}, published...)
+	sortByVersion(trailed, o.TagPrefix)
+	n := min(o.TrailCount, len(trailed))
+	for _, release := range trailed[:n] {
+		o.decide(Decision{Release: release, Reason: ReasonTrailing}, "skipping release within trail count of latest", "trail_count", o.TrailCount)
+	}
+	checks := o.releaseChecks()
+	candidates := []*Release{}
+	for _, release := range trailed[n:] {
+		// published releases passed the publish date gate, so it parses
+		publishedAt, _ := ParseTimestamp(release.PublishedAt)
+		if o.eligible(release, checks, PolicyContext{Now: now, PublishedAt: publishedAt, Logger: o.logger()}) {
+			candidates = append(candidates, release)
+		}
+	}
+	return candidates
+}
+
+// crashResult is a prefetched CrashCount result
+type crashResult struct {
+	count int
+	err   error
+}
+
+// prefetchCrashCounts calls CrashCount concurrently for up to CrashPrefetch of the first
+// candidates. Errors are kept per candidate so they only surface if that candidate is reached.
+func (o *Options) prefetchCrashCounts(candidates []*Release) []crashResult {
+	if o.CrashCount == nil || o.CrashPrefetch < 2 {
+		return nil
+	}
+	n := min(o.CrashPrefetch, len(candidates))
+	results := make([]crashResult, n)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			count, err := o.CrashCount(crashVersion(o.versionTag(candidates[i].TagName)))
+			results[i] = crashResult{count: count, err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// NewestPrerelease returns the most recently published prerelease that isn't a draft,
+// or nil if there is none. No stable gates apply.
+func NewestPrerelease(releases []*Release) *Release {
+	releases = append([]*Release{}, releases...)
+	sortReleases(releases, (&Options{}).logger())
+	for _, release := range releases {
+		_, err := ParseTimestamp(release.PublishedAt)
+		if release.Prerelease && !release.Draft && err == nil {
+			return release
+		}
+	}
+	return nil
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// crashVersion returns the version crash reports for tag are filed under, the tag
+// without a leading v or V. Any other v in the tag is kept.
+func crashVersion(tag string) string {
+	if strings.HasPrefix(tag, "v") || strings.HasPrefix(tag, "V") {
+		return tag[1:]
+	}
+	return tag
+}
+
+// highestVersion returns the release with the highest version, or the first release
+// if none of them are versions
+func highestVersion(releases []*Release, prefix string) *Release {
+	sorted := append([]*Release{}, releases...)
+	sortByVersion(sorted, prefix)
+	return sorted[0]
+}
+
+// sortReleases sorts releases newest first by publish date.
+// Releases with an unparseable publish date are sorted last.
+func sortReleases(releases []*Release, logger *slog.Logger) {
+	publishedAt := make(map[*Release]time.Time, len(releases))
+	for _, release := range releases {
+		t, err := ParseTimestamp(release.PublishedAt)
+		if err != nil {
+			logger.Debug("sorting release last, can't parse published at", "tag", release.TagName, "published_at", release.PublishedAt)
+			continue
+		}
+		publishedAt[release] = t
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		a, aOk := publishedAt[releases[i]]
+		b, bOk := publishedAt[releases[j]]
+		if aOk != bOk {
+			return aOk
+		}
+		return a.After(b)
+	})
+}
+
+// countKeywordLines returns how many lines of body contain any of the keywords, ignoring case
+func countKeywordLines(body string, keywords []string) int {
+	count := 0
+	for _, line := range strings.Split(body, "\n") {
+		if containsKeyword(line, keywords) {
+			count++
+		}
+	}
+	return count
+}
+
+// containsKeyword reports if body contains any of the keywords, ignoring case.
+// An empty keyword matches every body.
+func containsKeyword(body string, keywords []string) bool {
+	body = strings.ToLower(body)
+	for _, keyword := range keywords {
+		if keyword == "" || strings.Contains(body, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
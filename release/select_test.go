@@ -0,0 +1,715 @@
+package release
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// testRelease builds a release published age ago
+func testRelease(tag string, age time.Duration, body string) *Release {
+	return &Release{
+		Name:        tag,
+		TagName:     tag,
+		PublishedAt: time.Now().Add(-age).UTC().Format(time.RFC3339),
+		Body:        body,
+	}
+}
+
+func TestSelectReleases(t *testing.T) {
+	// an unpublished draft would otherwise qualify as both stable and unstable
+	draft := testRelease("v2.1.0", 20*day, "Fix everything")
+	draft.Draft = true
+	releases := []*Release{
+		testRelease("v1.8.0", 40*day, "Fix spells"),
+		testRelease("v2.0.0", 1*day, "Fix zone crash"),
+		draft,
+		testRelease("v1.9.0", 10*day, "Fix login"),
+	}
+	crashes := map[string]int{"1.9.0": 2}
+
+	opts := DefaultOptions()
+	opts.CrashCount = func(version string) (int, error) {
+		return crashes[version], nil
+	}
+	reasons := map[string]Reason{}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+
+	stable, unstable, usedFallback, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "v2.0.0" {
+		t.Errorf("unstable = %s, want v2.0.0", unstable.TagName)
+	}
+	if stable.TagName != "v1.8.0" {
+		t.Errorf("stable = %s, want v1.8.0", stable.TagName)
+	}
+	if usedFallback {
+		t.Errorf("usedFallback = true, want false")
+	}
+	want := map[string]Reason{
+		"v2.0.0": ReasonTooNew,
+		"v1.9.0": ReasonHasCrashes,
+		"v1.8.0": ReasonSelected,
+		"v2.1.0": ReasonDraft,
+	}
+	for tag, reason := range want {
+		if reasons[tag] != reason {
+			t.Errorf("reason for %s = %s, want %s", tag, reasons[tag], reason)
+		}
+	}
+}
+
+func TestSelectReleasesVersionOrder(t *testing.T) {
+	// a hotfix to an older line published after the newer line
+	releases := []*Release{
+		testRelease("v1.9.5", 8*day, "Fix login"),
+		testRelease("v2.0.0", 12*day, "Fix zone crash"),
+		testRelease("v1.9.4", 20*day, "Fix spells"),
+	}
+	stable, unstable, _, err := SelectReleases(releases, DefaultOptions())
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "v2.0.0" {
+		t.Errorf("unstable = %s, want v2.0.0", unstable.TagName)
+	}
+	if stable.TagName != "v2.0.0" {
+		t.Errorf("stable = %s, want v2.0.0", stable.TagName)
+	}
+}
+
+func TestSelectReleasesPrereleasesOnly(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.1.0-rc1", 2*day, "Fix crash"),
+		testRelease("v2.1.0-rc2", 1*day, "Fix crash"),
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}
+	releases[0].Prerelease = true
+	releases[1].Prerelease = true
+
+	opts := Options{Prereleases: PrereleasesOnly}
+	_, unstable, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "v2.1.0-rc2" {
+		t.Errorf("unstable = %s, want v2.1.0-rc2", unstable.TagName)
+	}
+}
+
+func TestSelectReleasesAgeBoundary(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(tag string, age time.Duration) *Release {
+		return &Release{Name: tag, TagName: tag, PublishedAt: now.Add(-age).Format(time.RFC3339), Body: "Fix crash"}
+	}
+	tests := []struct {
+		name       string
+		age        time.Duration
+		wantReason Reason
+	}{
+		{name: "a second short of min age", age: 7*day - time.Second, wantReason: ReasonTooNew},
+		{name: "exactly min age", age: 7 * day, wantReason: ReasonSelected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := map[string]Reason{}
+			opts := DefaultOptions()
+			opts.Now = func() time.Time { return now }
+			opts.OnDecision = func(decision Decision) {
+				reasons[decision.Release.TagName] = decision.Reason
+			}
+			// the 31 day old release is the fallback when the newer one is too new
+			SelectReleases([]*Release{at("v2.0.0", tt.age), at("v1.0.0", 31*day)}, opts)
+			if reasons["v2.0.0"] != tt.wantReason {
+				t.Errorf("v2.0.0 reason = %s, want %s", reasons["v2.0.0"], tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSelectReleasesGap(t *testing.T) {
+	base := time.Now().Add(-60 * day).Truncate(time.Second)
+	at := func(tag string, publishedAt time.Time) *Release {
+		return &Release{Name: tag, TagName: tag, PublishedAt: publishedAt.UTC().Format(time.RFC3339), Body: "Fix crash"}
+	}
+	releases := []*Release{
+		at("v2.0.0", base),
+		// exactly the gap before v2.0.0
+		at("v1.9.0", base.Add(-3*day)),
+		// a second short of the gap before v1.9.0
+		at("v1.8.0", base.Add(-6*day+time.Second)),
+		// the gap is measured from v1.8.0 even though it was skipped
+		at("v1.7.0", base.Add(-9*day+time.Second)),
+	}
+
+	reasons := map[string]Reason{}
+	opts := DefaultOptions()
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+	_, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	want := map[string]bool{"v2.0.0": false, "v1.9.0": false, "v1.8.0": true, "v1.7.0": false}
+	for tag, tooClose := range want {
+		if (reasons[tag] == ReasonTooClose) != tooClose {
+			t.Errorf("%s reason = %q, want too close %t", tag, reasons[tag], tooClose)
+		}
+	}
+}
+
+func TestSelectReleasesGapCollapse(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.1.0", 8*day, "New zone"),
+		testRelease("v2.0.1", 9*day, "Fix login"),
+		// a backported fix published just before v2.0.1 but with a higher version
+		testRelease("v2.0.2", 9*day+12*time.Hour, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix spells"),
+	}
+	tests := []struct {
+		behavior    GapBehavior
+		crashing    string
+		wantStable  string
+		wantReasons map[string]Reason
+	}{
+		{
+			behavior:   GapSkip,
+			wantStable: "v1.9.0",
+			wantReasons: map[string]Reason{
+				"v2.1.0": ReasonNoFix, "v2.0.1": ReasonTooClose, "v2.0.2": ReasonTooClose, "v1.9.0": ReasonSelected,
+			},
+		},
+		{
+			behavior:   GapCollapse,
+			wantStable: "v2.0.2",
+			wantReasons: map[string]Reason{
+				"v2.1.0": ReasonNoFix, "v2.0.1": ReasonTooClose, "v2.0.2": ReasonSelected,
+			},
+		},
+		{
+			// the clean lower version of the cluster is used when the highest has crashes
+			behavior:   GapCollapse,
+			crashing:   "2.0.2",
+			wantStable: "v2.0.1",
+			wantReasons: map[string]Reason{
+				"v2.1.0": ReasonNoFix, "v2.0.1": ReasonSelected, "v2.0.2": ReasonHasCrashes,
+			},
+		},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.GapBehavior = tt.behavior
+		opts.CrashCount = func(version string) (int, error) {
+			if version == tt.crashing {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		reasons := map[string]Reason{}
+		opts.OnDecision = func(decision Decision) {
+			reasons[decision.Release.TagName] = decision.Reason
+		}
+		stable, _, _, err := SelectReleases(releases, opts)
+		if err != nil {
+			t.Fatalf("SelectReleases() error = %v", err)
+		}
+		if stable.TagName != tt.wantStable {
+			t.Errorf("behavior %d: stable = %s, want %s", tt.behavior, stable.TagName, tt.wantStable)
+		}
+		if !reflect.DeepEqual(reasons, tt.wantReasons) {
+			t.Errorf("behavior %d: reasons = %v, want %v", tt.behavior, reasons, tt.wantReasons)
+		}
+	}
+}
+
+func TestSelectReleasesCrashPrefetch(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+		testRelease("v1.8.0", 30*day, "Fix spells"),
+	}
+	for _, prefetch := range []int{0, 2, 10} {
+		opts := DefaultOptions()
+		opts.CrashPrefetch = prefetch
+		opts.CrashCount = func(version string) (int, error) {
+			switch version {
+			case "2.0.0":
+				return 3, nil
+			case "1.8.0":
+				// never reached, so it mustn't fail the selection
+				return 0, errors.New("unreachable")
+			}
+			return 0, nil
+		}
+		stable, _, _, err := SelectReleases(releases, opts)
+		if err != nil {
+			t.Fatalf("prefetch %d: SelectReleases() error = %v", prefetch, err)
+		}
+		if stable.TagName != "v1.9.0" {
+			t.Errorf("prefetch %d: stable = %s, want v1.9.0", prefetch, stable.TagName)
+		}
+	}
+}
+
+func TestSelectReleasesMaxCandidates(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+		testRelease("v1.8.0", 40*day, "New spells"),
+	}
+	checked := []string{}
+	opts := DefaultOptions()
+	opts.MaxCandidates = 1
+	opts.CrashCount = func(version string) (int, error) {
+		checked = append(checked, version)
+		return 1, nil
+	}
+
+	stable, _, usedFallback, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if stable.TagName != "v1.8.0" || !usedFallback {
+		t.Errorf("stable = %s, used fallback %t, want the v1.8.0 fallback", stable.TagName, usedFallback)
+	}
+	if len(checked) != 1 {
+		t.Errorf("crash checked %q, want only the first candidate", checked)
+	}
+}
+
+func TestSelectReleasesTrailCount(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 1*day, "New zone"),
+		testRelease("v1.9.0", 2*day, "New spells"),
+		testRelease("v1.8.0", 3*day, "New items"),
+		testRelease("v1.7.0", 4*day, "New quests"),
+	}
+	opts := DefaultOptions()
+	opts.TrailCount = 1
+	opts.CrashCount = func(version string) (int, error) {
+		if version == "1.9.0" {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	stable, unstable, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "v2.0.0" {
+		t.Errorf("unstable = %s, want v2.0.0", unstable.TagName)
+	}
+	// v1.9.0 trails by one but has crashes, so selection moves further back
+	if stable.TagName != "v1.8.0" {
+		t.Errorf("stable = %s, want v1.8.0", stable.TagName)
+	}
+}
+
+func TestSelectReleasesTrailCountReleaseChecks(t *testing.T) {
+	withAsset := func(rel *Release) *Release {
+		rel.Assets = []Asset{{Name: "eqemu-server-linux-x64.zip"}}
+		return rel
+	}
+	releases := []*Release{
+		withAsset(testRelease("v2.0.0", 1*day, "New zone with a long list of changes")),
+		testRelease("v1.9.0", 2*day, "New spells with a long list of changes"),
+		withAsset(testRelease("v1.8.0", 3*day, ".")),
+		withAsset(testRelease("v1.7.0", 4*day, "New quests with a long list of changes")),
+	}
+	opts := DefaultOptions()
+	opts.TrailCount = 1
+	opts.RequireAssets = []string{"*linux*"}
+	opts.MinBodyLength = 10
+	reasons := map[string]Reason{}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	// v1.9.0 trails by one but has no server binary and v1.8.0 has no notes
+	if stable.TagName != "v1.7.0" {
+		t.Errorf("stable = %s, want v1.7.0", stable.TagName)
+	}
+	want := map[string]Reason{
+		"v2.0.0": ReasonTrailing,
+		"v1.9.0": ReasonMissingAsset,
+		"v1.8.0": ReasonEmptyNotes,
+		"v1.7.0": ReasonSelected,
+	}
+	for tag, reason := range want {
+		if reasons[tag] != reason {
+			t.Errorf("%s reason = %s, want %s", tag, reasons[tag], reason)
+		}
+	}
+}
+
+func TestSelectReleasesTagPrefix(t *testing.T) {
+	releases := []*Release{
+		testRelease("tools-v3.0.0", 10*day, "Fix exporter"),
+		testRelease("server-v2.0.0", 11*day, "Fix zone crash"),
+		testRelease("server-v1.9.0", 20*day, "Fix login"),
+	}
+	opts := DefaultOptions()
+	opts.TagPrefix = "server-"
+	versions := []string{}
+	opts.CrashCount = func(version string) (int, error) {
+		versions = append(versions, version)
+		if version == "2.0.0" {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	filtered := []string{}
+	opts.OnDecision = func(decision Decision) {
+		if decision.Reason == ReasonTagFiltered {
+			filtered = append(filtered, decision.Release.TagName)
+		}
+	}
+
+	stable, unstable, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "server-v2.0.0" || stable.TagName != "server-v1.9.0" {
+		t.Errorf("SelectReleases() = %s, %s, want server-v1.9.0, server-v2.0.0", stable.TagName, unstable.TagName)
+	}
+	if !reflect.DeepEqual(filtered, []string{"tools-v3.0.0"}) {
+		t.Errorf("filtered %v, want [tools-v3.0.0]", filtered)
+	}
+	if !reflect.DeepEqual(versions, []string{"2.0.0", "1.9.0"}) {
+		t.Errorf("crash checked %v, want [2.0.0 1.9.0]", versions)
+	}
+}
+
+func TestSelectReleasesMinBodyLength(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.1.0", 10*day, ""),
+		testRelease("v2.0.0", 14*day, " . "),
+		testRelease("v1.9.0", 20*day, "Login rework"),
+	}
+	opts := DefaultOptions()
+	// without the keyword policy only the body length keeps undocumented releases out
+	opts.Policies = []SelectionPolicy{MinAgePolicy{MinAge: opts.MinAge}}
+	opts.MinBodyLength = 5
+	empty := []string{}
+	opts.OnDecision = func(decision Decision) {
+		if decision.Reason == ReasonEmptyNotes {
+			empty = append(empty, decision.Release.TagName)
+		}
+	}
+
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if stable.TagName != "v1.9.0" {
+		t.Errorf("stable = %s, want v1.9.0", stable.TagName)
+	}
+	if !reflect.DeepEqual(empty, []string{"v2.1.0", "v2.0.0"}) {
+		t.Errorf("empty notes %v, want [v2.1.0 v2.0.0]", empty)
+	}
+}
+
+func TestSelectReleasesRequireAssets(t *testing.T) {
+	withAssets := func(rel *Release, names ...string) *Release {
+		for _, name := range names {
+			rel.Assets = append(rel.Assets, Asset{Name: name})
+		}
+		return rel
+	}
+	releases := []*Release{
+		// the linux upload failed
+		withAssets(testRelease("v2.0.0", 10*day, "Fix zone crash"), "eqemu-server-windows-x64.zip"),
+		withAssets(testRelease("v1.9.0", 20*day, "Fix login"), "eqemu-server-linux-x64.zip", "eqemu-server-windows-x64.zip"),
+	}
+	opts := DefaultOptions()
+	opts.RequireAssets = []string{"*linux*", "*windows*"}
+	reasons := map[string]Reason{}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if stable.TagName != "v1.9.0" || reasons["v2.0.0"] != ReasonMissingAsset {
+		t.Errorf("stable = %s, v2.0.0 reason %s, want v1.9.0 with v2.0.0 missing an asset", stable.TagName, reasons["v2.0.0"])
+	}
+}
+
+func TestSelectReleasesScore(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login\nFix spells\nFix pathing"),
+	}
+	crashes := map[string]int{"2.0.0": 2}
+	for _, mode := range []SelectionMode{SelectFirst, SelectScore} {
+		opts := DefaultOptions()
+		opts.SelectionMode = mode
+		opts.MaxCrashServers = 2
+		opts.CrashCount = func(version string) (int, error) {
+			return crashes[version], nil
+		}
+		reasons := map[string]Reason{}
+		opts.OnDecision = func(decision Decision) {
+			reasons[decision.Release.TagName] = decision.Reason
+		}
+
+		stable, _, _, err := SelectReleases(releases, opts)
+		if err != nil {
+			t.Fatalf("SelectReleases() error = %v", err)
+		}
+		// v2.0.0 is newer, but its crashes cost more than the extra fixes in v1.9.0
+		want := map[SelectionMode]map[string]Reason{
+			SelectFirst: {"v2.0.0": ReasonSelected},
+			SelectScore: {"v2.0.0": ReasonOutscored, "v1.9.0": ReasonSelected},
+		}[mode]
+		if !reflect.DeepEqual(reasons, want) {
+			t.Errorf("mode %d: stable %s, reasons = %v, want %v", mode, stable.TagName, reasons, want)
+		}
+	}
+}
+
+func TestSelectReleasesStableCount(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.1.0", 10*day, "Fix zone crash"),
+		testRelease("v2.0.0", 15*day, "Fix login"),
+		testRelease("v1.9.0", 20*day, "Fix spells\nFix pathing\nFix items\nFix pets\nFix trades"),
+		testRelease("v1.8.0", 25*day, "Fix items"),
+	}
+	crashes := map[string]int{"2.0.0": 1}
+	tests := []struct {
+		mode  SelectionMode
+		count int
+		want  []string
+	}{
+		{mode: SelectFirst, count: 1, want: []string{"v2.1.0"}},
+		// the crashing v2.0.0 is passed over, and v1.8.0 isn't crash checked once two are found
+		{mode: SelectFirst, count: 2, want: []string{"v2.1.0", "v1.9.0"}},
+		{mode: SelectFirst, count: 5, want: []string{"v2.1.0", "v1.9.0", "v1.8.0"}},
+		{mode: SelectScore, count: 2, want: []string{"v1.9.0", "v2.1.0"}},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.SelectionMode = tt.mode
+		opts.StableCount = tt.count
+		checked := []string{}
+		opts.CrashCount = func(version string) (int, error) {
+			checked = append(checked, version)
+			return crashes[version], nil
+		}
+		selected := []string{}
+		opts.OnDecision = func(decision Decision) {
+			if decision.Reason == ReasonSelected || decision.Reason == ReasonCandidate {
+				selected = append(selected, decision.Release.TagName)
+			}
+		}
+		stable, _, _, err := SelectReleases(releases, opts)
+		if err != nil {
+			t.Fatalf("SelectReleases() error = %v", err)
+		}
+		if stable.TagName != tt.want[0] || !reflect.DeepEqual(selected, tt.want) {
+			t.Errorf("mode %d count %d: stable = %s, selected %v, want %v", tt.mode, tt.count, stable.TagName, selected, tt.want)
+		}
+		if tt.mode == SelectFirst && tt.count == 2 && !reflect.DeepEqual(checked, []string{"2.1.0", "2.0.0", "1.9.0"}) {
+			t.Errorf("crash checked %v, want the search to stop after two candidates", checked)
+		}
+	}
+}
+
+func TestSelectReleasesPromoteFrom(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.1.0", 10*day, "Fix zone crash"),
+		testRelease("v2.0.0", 15*day, "Fix login"),
+		testRelease("v1.9.0", 20*day, "Fix spells"),
+		testRelease("v1.8.0", 25*day, "Fix items"),
+	}
+	tests := []struct {
+		promoteFrom string
+		crashing    string
+		want        string
+	}{
+		{promoteFrom: "v1.8.0", want: "v1.9.0"},
+		// a crashing next release is passed over for the one after it
+		{promoteFrom: "v1.8.0", crashing: "1.9.0", want: "v2.0.0"},
+		{promoteFrom: "v2.1.0", want: "v2.1.0"},
+		{promoteFrom: "v9.9.9", want: "v2.1.0"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.PromoteFrom = tt.promoteFrom
+		opts.CrashCount = func(version string) (int, error) {
+			if version == tt.crashing {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		stable, _, usedFallback, err := SelectReleases(releases, opts)
+		if err != nil {
+			t.Fatalf("SelectReleases() error = %v", err)
+		}
+		if stable.TagName != tt.want || usedFallback {
+			t.Errorf("promote from %s: stable = %s, used fallback %t, want %s", tt.promoteFrom, stable.TagName, usedFallback, tt.want)
+		}
+	}
+}
+
+func TestSelectReleasesCrashSoftFail(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}
+	opts := DefaultOptions()
+	opts.CrashCount = func(version string) (int, error) {
+		if version == "2.0.0" {
+			return 0, errors.New("spire unavailable")
+		}
+		return 0, nil
+	}
+
+	_, _, _, err := SelectReleases(releases, opts)
+	if !errors.Is(err, ErrCrashFetch) || !strings.Contains(err.Error(), "spire unavailable") {
+		t.Fatalf("SelectReleases() error = %v, want the crash count error as ErrCrashFetch", err)
+	}
+	opts.CrashSoftFail = true
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if stable.TagName != "v1.9.0" {
+		t.Errorf("stable = %s, want v1.9.0", stable.TagName)
+	}
+}
+
+// tagPolicy rejects a single tag
+type tagPolicy string
+
+func (p tagPolicy) Eligible(rel *Release, ctx PolicyContext) (bool, Reason) {
+	return rel.TagName != string(p), "BLOCKED"
+}
+
+func TestSelectReleasesCustomPolicy(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "New zone"),
+		testRelease("v1.9.0", 20*day, "New spells"),
+	}
+	reasons := map[string]Reason{}
+	opts := DefaultOptions()
+	opts.Policies = []SelectionPolicy{MinAgePolicy{MinAge: 7 * day}, tagPolicy("v2.0.0")}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	// the keyword policy isn't in the chain, so v1.9.0 qualifies without a fix
+	if stable.TagName != "v1.9.0" {
+		t.Errorf("stable = %s, want v1.9.0", stable.TagName)
+	}
+	if reasons["v2.0.0"] != "BLOCKED" {
+		t.Errorf("v2.0.0 reason = %s, want BLOCKED", reasons["v2.0.0"])
+	}
+}
+
+func TestSelectReleasesLowAdoption(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+		testRelease("v1.9.0", 20*day, "Fix login"),
+	}
+	running := map[string]int{"2.0.0": 1, "1.9.0": 5}
+
+	opts := DefaultOptions()
+	opts.MinAdoption = 3
+	// no crash reports for v2.0.0 only means nobody is running it yet
+	opts.CrashCount = func(version string) (int, error) {
+		return 0, nil
+	}
+	opts.AdoptionCount = func(version string) (int, error) {
+		return running[version], nil
+	}
+	reasons := map[string]Reason{}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+
+	stable, _, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if stable.TagName != "v1.9.0" {
+		t.Errorf("stable = %s, want v1.9.0", stable.TagName)
+	}
+	if reasons["v2.0.0"] != ReasonLowAdoption {
+		t.Errorf("v2.0.0 reason = %s, want %s", reasons["v2.0.0"], ReasonLowAdoption)
+	}
+}
+
+func TestSelectReleasesUnpublished(t *testing.T) {
+	draft := testRelease("v2.1.0", 0, "Fix crash")
+	draft.PublishedAt = ""
+	garbled := testRelease("v2.2.0", 0, "Fix crash")
+	garbled.PublishedAt = "18 Sep 2023"
+	releases := []*Release{
+		draft,
+		garbled,
+		testRelease("v2.0.0", 10*day, "Fix zone crash"),
+	}
+	opts := DefaultOptions()
+	reasons := map[string]Reason{}
+	opts.OnDecision = func(decision Decision) {
+		reasons[decision.Release.TagName] = decision.Reason
+	}
+	stable, unstable, _, err := SelectReleases(releases, opts)
+	if err != nil {
+		t.Fatalf("SelectReleases() error = %v", err)
+	}
+	if unstable.TagName != "v2.0.0" || stable.TagName != "v2.0.0" {
+		t.Errorf("SelectReleases() = %s, %s, want v2.0.0, v2.0.0", stable.TagName, unstable.TagName)
+	}
+	if reasons["v2.2.0"] != ReasonUnpublished {
+		t.Errorf("v2.2.0 reason = %q, want %q", reasons["v2.2.0"], ReasonUnpublished)
+	}
+}
+
+func TestSelectReleasesNoRelease(t *testing.T) {
+	releases := []*Release{
+		testRelease("v2.0.0", 10*day, "New zone"),
+	}
+	_, _, _, err := SelectReleases(releases, DefaultOptions())
+	if !errors.Is(err, ErrNoRelease) {
+		t.Errorf("SelectReleases() error = %v, want ErrNoRelease", err)
+	}
+}
+
+func TestCrashVersion(t *testing.T) {
+	tests := map[string]string{
+		"v1.2.3":     "1.2.3",
+		"V1.2.3":     "1.2.3",
+		"1.2.3":      "1.2.3",
+		"v1.2.3-dev": "1.2.3-dev",
+		"server-v1":  "server-v1",
+		"vv1.0.0":    "v1.0.0",
+	}
+	for tag, want := range tests {
+		got := crashVersion(tag)
+		if got != want {
+			t.Errorf("crashVersion(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
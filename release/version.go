@@ -0,0 +1,84 @@
+package release
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// semverTag matches release tags in the form vMAJOR.MINOR.PATCH, with an optional
+// -prerelease and +build suffix
+var semverTag = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// version is a parsed vMAJOR.MINOR.PATCH tag
+type version struct {
+	numbers [3]int
+	// prerelease is the -prerelease suffix, empty for a release
+	prerelease string
+}
+
+// parseVersion parses a vMAJOR.MINOR.PATCH tag
+func parseVersion(tag string) (version, bool) {
+	match := semverTag.FindStringSubmatch(tag)
+	if match == nil {
+		return version{}, false
+	}
+	v := version{prerelease: match[4]}
+	for i := range v.numbers {
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return version{}, false
+		}
+		v.numbers[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is lower, equal to or higher than other.
+// A prerelease is lower than the release it precedes.
+func (v version) compare(other version) int {
+	for i := range v.numbers {
+		if v.numbers[i] != other.numbers[i] {
+			if v.numbers[i] < other.numbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	case v.prerelease < other.prerelease:
+		return -1
+	}
+	return 1
+}
+
+// CompareVersions returns -1, 0 or 1 as tag a is a lower, equal or higher version than
+// tag b. ok is false if either tag isn't a vMAJOR.MINOR.PATCH version.
+func CompareVersions(a, b string) (result int, ok bool) {
+	va, aOk := parseVersion(a)
+	vb, bOk := parseVersion(b)
+	if !aOk || !bOk {
+		return 0, false
+	}
+	return va.compare(vb), true
+}
+
+// sortByVersion sorts releases highest version first, parsing tags without prefix. Tags
+// that aren't versions sort after those that are, keeping their existing order.
+func sortByVersion(releases []*Release, prefix string) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		a, aOk := parseVersion(strings.TrimPrefix(releases[i].TagName, prefix))
+		b, bOk := parseVersion(strings.TrimPrefix(releases[j].TagName, prefix))
+		if aOk != bOk {
+			return aOk
+		}
+		return aOk && a.compare(b) > 0
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eqemu-pack/server/release"
+)
+
+// stateFile is the file in the out dir recording the tags chosen by the last run
+const stateFile = "state.json"
+
+// errRegression is returned by run when stable moved to an older version and failOnRegression is set
+var errRegression = errors.New("stable release regressed")
+
+// runState is the on disk format of the state file, tags are empty for channels that weren't selected
+type runState struct {
+	Stable   string `json:"stable,omitempty"`
+	Unstable string `json:"unstable,omitempty"`
+	// Version is the version of the tool that wrote the state
+	Version string `json:"version,omitempty"`
+}
+
+// loadState returns the state recorded at path, or nil if there is none
+func loadState(path string) (*runState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	state := &runState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		logger.Warn("ignoring state file that can't be decoded", "path", path, "err", err)
+		return nil, nil
+	}
+	return state, nil
+}
+
+// checkRegression logs a warning if stable is an older version than the last run's, returning
+// errRegression if failOnRegression is set. Tags are compared without tagPrefix, those that
+// aren't versions can't be compared and pass.
+func checkRegression(previous *runState, stable *release.Release, tagPrefix string, failOnRegression bool) error {
+	if previous == nil || previous.Stable == "" || stable == nil {
+		return nil
+	}
+	result, ok := release.CompareVersions(strings.TrimPrefix(stable.TagName, tagPrefix), strings.TrimPrefix(previous.Stable, tagPrefix))
+	if !ok || result >= 0 {
+		return nil
+	}
+	logger.Warn("REGRESSION: stable release is older than the last run's", "tag", stable.TagName, "previous", previous.Stable)
+	if !failOnRegression {
+		return nil
+	}
+	return fmt.Errorf("%w: %s to %s", errRegression, previous.Stable, stable.TagName)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// buildVersion, buildCommit and buildDate describe the build, set at build time with e.g.
+// -ldflags "-X main.buildVersion=v1.2.3 -X main.buildCommit=abc123 -X main.buildDate=2024-01-02".
+// Unset values are taken from the module version and vcs stamping if the build has them.
+var (
+	buildVersion = ""
+	buildCommit  = ""
+	buildDate    = ""
+)
+
+// buildInfoJson describes the running build, as printed by version -format json
+type buildInfoJson struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// currentBuild returns the build the tool is running, with version dev if it isn't known
+func currentBuild() *buildInfoJson {
+	build := &buildInfoJson{Version: buildVersion, Commit: buildCommit, Date: buildDate, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		if build.Version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			build.Version = info.Main.Version
+		}
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if build.Commit == "" {
+					build.Commit = setting.Value
+				}
+			case "vcs.time":
+				if build.Date == "" {
+					build.Date = setting.Value
+				}
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && buildCommit == "" && build.Commit != "" {
+			build.Commit += "-dirty"
+		}
+	}
+	if build.Version == "" {
+		build.Version = "dev"
+	}
+	return build
+}
+
+// writeVersion writes the current build to w, as json if asJSON is set
+func writeVersion(w io.Writer, asJSON bool) error {
+	build := currentBuild()
+	if asJSON {
+		data, err := marshalOutput(build, true)
+		if err != nil {
+			return fmt.Errorf("marshal version: %w", err)
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return fmt.Errorf("write version: %w", err)
+		}
+		return nil
+	}
+	line := "eqemu-pack-server " + build.Version
+	if build.Commit != "" {
+		line += " commit " + build.Commit
+	}
+	if build.Date != "" {
+		line += " built " + build.Date
+	}
+	_, err := fmt.Fprintln(w, line, build.GoVersion)
+	if err != nil {
+		return fmt.Errorf("write version: %w", err)
+	}
+	return nil
+}